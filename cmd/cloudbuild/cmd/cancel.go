@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"github.com/ikedam/cloudbuild/internal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// cancelCmd represents the cancel command
+var cancelCmd = &cobra.Command{
+	Use:   "cancel [BUILD_ID...]",
+	Short: "Cancel builds",
+	Args:  cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		initLevel()
+		cancel := &internal.CloudBuildCancel{}
+		if err := func() error {
+			if err := viper.Unmarshal(&cancel.Config); err != nil {
+				return internal.NewConfigError("Failed to parse configurations", err)
+			}
+			flags := cmd.Flags()
+			var err error
+			if cancel.AllOngoing, err = flags.GetBool("all-ongoing"); err != nil {
+				return err
+			}
+			if cancel.Tags, err = flags.GetStringSlice("tag"); err != nil {
+				return err
+			}
+			if cancel.Filter, err = flags.GetString("filter"); err != nil {
+				return err
+			}
+			if cancel.Yes, err = flags.GetBool("yes"); err != nil {
+				return err
+			}
+			if cancel.DryRun, err = flags.GetBool("dry-run"); err != nil {
+				return err
+			}
+			cancel.BuildIDs = args
+			return cancel.Execute()
+		}(); err != nil {
+			exitForError(err, "Failed to cancel builds")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cancelCmd)
+
+	cancelCmd.Flags().Bool("all-ongoing", false, "Cancel all queued or working builds matching --tag and --filter.")
+	cancelCmd.Flags().StringSlice("tag", []string{}, "Tag of builds to cancel with --all-ongoing. Accepts multiple times.")
+	cancelCmd.Flags().String("filter", "", "Filter expression of Cloud Build to select builds to cancel with --all-ongoing.")
+	cancelCmd.Flags().Bool("yes", false, "Allow --all-ongoing without --tag and --filter to cancel all ongoing builds in the project.")
+	cancelCmd.Flags().Bool("dry-run", false, "Only print ids of builds to cancel.")
+}
@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ikedam/cloudbuild/internal"
+	"github.com/ikedam/cloudbuild/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// settingFlags maps keys of settings to persistent flags available for all commands.
+var settingFlags = map[string]string{
+	"project":    "project",
+	"logLevel":   "log-level",
+	"logFormat":  "log-format",
+	"logOutput":  "log-output",
+	"alwaysDump": "always-dump",
+	"profile":    "profile",
+
+	"sensitiveKeyPatterns": "sensitive-key-pattern",
+
+	"credentialsFile":           "credentials-file",
+	"accessTokenFile":           "access-token-file",
+	"impersonateServiceAccount": "impersonate-service-account",
+
+	"pollingInterval":   "polling-interval",
+	"uploadTimeout":     "upload-timeout",
+	"cloudBuildTimeout": "cloud-build-timeout",
+	"readLogTimeout":    "read-log-timeout",
+}
+
+// durationFlagUsages is usages of flags for settings of durations.
+var durationFlagUsages = map[string]string{
+	"pollingInterval":   "Interval for polling build statuses and logs.",
+	"uploadTimeout":     "Timeout to upload the source archive. 0 for no timeout.",
+	"cloudBuildTimeout": "Timeout of each operation of Cloud Build. 0 for no timeout.",
+	"readLogTimeout":    "Timeout to read build logs from Cloud Storage. 0 for no timeout.",
+}
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show and edit settings",
+}
+
+// configViewCmd represents the config view command
+var configViewCmd = &cobra.Command{
+	Use:   "view",
+	Short: "Show effective settings and where they come from",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		initLevel()
+		config := &internal.Config{}
+		if err := viper.Unmarshal(config); err != nil {
+			exitForError(internal.NewConfigError("Failed to parse configurations", err), "Failed to show settings")
+		}
+		settings := internal.ConfigSettings(config)
+		for _, key := range []string{"logLevel", "logFormat", "logOutput", "alwaysDump", "profile", "sensitiveKeyPatterns"} {
+			settings = append(settings, &internal.ConfigSetting{
+				Key:   key,
+				Value: viper.Get(key),
+			})
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+		for _, setting := range settings {
+			fmt.Fprintf(
+				w,
+				"%v\t%v\t%v\n",
+				setting.Key,
+				formatSettingValue(setting.Value),
+				settingSource(cmd, setting.Key, setting.Value),
+			)
+		}
+		w.Flush()
+	},
+}
+
+// configSetCmd represents the config set command
+var configSetCmd = &cobra.Command{
+	Use:   "set KEY VALUE",
+	Short: "Set a setting in the settings file of the user",
+	Long: `Set a setting in the settings file of the user ($HOME/.cloudbuildconfig.yaml),
+or the file specified with --settings.
+VALUE is parsed as YAML. Use KEY like profiles.NAME.KEY to set settings of profiles.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		initLevel()
+		file := editedSettingsFile()
+		if err := internal.SetSetting(file, args[0], args[1]); err != nil {
+			exitForError(err, "Failed to set the setting")
+		}
+		log.WithField("file", file).WithField("key", args[0]).Info("Set the setting")
+	},
+}
+
+// configUnsetCmd represents the config unset command
+var configUnsetCmd = &cobra.Command{
+	Use:   "unset KEY",
+	Short: "Remove a setting from the settings file of the user",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		initLevel()
+		file := editedSettingsFile()
+		removed, err := internal.UnsetSetting(file, args[0])
+		if err != nil {
+			exitForError(err, "Failed to unset the setting")
+		}
+		if !removed {
+			log.WithField("file", file).WithField("key", args[0]).Warning("The setting is not in the file")
+			return
+		}
+		log.WithField("file", file).WithField("key", args[0]).Info("Removed the setting")
+	},
+}
+
+// configValidateCmd represents the config validate command
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [FILE...]",
+	Short: "Check settings files have no unknown keys or invalid values",
+	Long: `Check settings files have no unknown keys or invalid values.
+Checks settings files read by the command if no files are specified.`,
+	Args: cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		initLevel()
+		files := args
+		if len(files) == 0 {
+			for _, layer := range settingsLayers {
+				if _, err := os.Stat(layer.name); err == nil {
+					files = append(files, layer.name)
+				}
+			}
+		}
+		if len(files) == 0 {
+			log.Info("No settings files to validate")
+			return
+		}
+		var lastErr error
+		for _, file := range files {
+			settings, err := readSettingsFile(file)
+			if err == nil {
+				err = internal.ValidateSettings(settings.AllSettings())
+			}
+			if err != nil {
+				log.WithError(err).WithField("file", file).Error("Invalid settings file")
+				lastErr = err
+				continue
+			}
+			fmt.Printf("%v: OK\n", file)
+		}
+		if lastErr != nil {
+			log.Exit(internal.ExitCodeForError(internal.NewConfigError("Invalid settings files", lastErr)))
+		}
+	},
+}
+
+// editedSettingsFile returns the settings file to edit.
+func editedSettingsFile() string {
+	if cfgFile != "" {
+		return cfgFile
+	}
+	return userSettingsFile
+}
+
+// settingSource returns where the setting comes from.
+func settingSource(cmd *cobra.Command, key string, value interface{}) string {
+	if source := explicitSettingSource(cmd, key); source != "" {
+		return source
+	}
+	for _, setting := range internal.DurationSettings {
+		if setting.Key != key {
+			continue
+		}
+		if source := explicitSettingSource(cmd, setting.DeprecatedMsecKey); source != "" {
+			return fmt.Sprintf("%v (%v)", source, setting.DeprecatedMsecKey)
+		}
+	}
+	if value == nil {
+		return "-"
+	}
+	if rv := reflect.ValueOf(value); rv.IsZero() || (rv.Kind() == reflect.Slice && rv.Len() == 0) {
+		return "-"
+	}
+	return "default"
+}
+
+// explicitSettingSource returns where the setting is specified:
+// a flag, an environment variable, a settings file or a profile.
+// Returns an empty string if the setting isn't specified.
+func explicitSettingSource(cmd *cobra.Command, key string) string {
+	if flag, ok := settingFlags[key]; ok && cmd.Flags().Changed(flag) {
+		return fmt.Sprintf("flag --%v", flag)
+	}
+	env := fmt.Sprintf("CLOUDBUILD_%v", strings.ToUpper(key))
+	if _, ok := os.LookupEnv(env); ok {
+		return fmt.Sprintf("env %v", env)
+	}
+	for idx := len(settingsLayers) - 1; idx >= 0; idx-- {
+		if settingsLayers[idx].settings.IsSet(key) {
+			return settingsLayers[idx].name
+		}
+	}
+	return ""
+}
+
+// formatSettingValue formats the value of the setting for tables.
+func formatSettingValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "-"
+	case string:
+		if v == "" {
+			return "-"
+		}
+		return v
+	case []string:
+		if len(v) == 0 {
+			return "-"
+		}
+		return strings.Join(v, ",")
+	}
+	if rv := reflect.ValueOf(value); rv.Kind() == reflect.Slice {
+		if rv.Len() == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("(%v items)", rv.Len())
+	}
+	return fmt.Sprint(value)
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configViewCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configUnsetCmd)
+	configCmd.AddCommand(configValidateCmd)
+}
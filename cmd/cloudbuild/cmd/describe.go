@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"github.com/ikedam/cloudbuild/internal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// describeCmd represents the describe command
+var describeCmd = &cobra.Command{
+	Use:   "describe BUILD_ID",
+	Short: "Show details of a build",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		initLevel()
+		describe := &internal.CloudBuildDescribe{}
+		if err := func() error {
+			if err := viper.Unmarshal(&describe.Config); err != nil {
+				return internal.NewConfigError("Failed to parse configurations", err)
+			}
+			var err error
+			if describe.Output, err = cmd.Flags().GetString("output"); err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("sensitive-substitution") {
+				if describe.Config.SensitiveSubstitutions, err = cmd.Flags().GetStringSlice("sensitive-substitution"); err != nil {
+					return err
+				}
+			}
+			describe.BuildID = args[0]
+			return describe.Execute()
+		}(); err != nil {
+			exitForError(err, "Failed to describe the build")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(describeCmd)
+
+	describeCmd.Flags().StringP("output", "o", internal.OutputFormatText, "Output format: text, json or yaml.")
+	describeCmd.Flags().StringSlice("sensitive-substitution", []string{}, "Key of a substitution to mask its value. Accepts multiple times.")
+}
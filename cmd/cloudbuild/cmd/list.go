@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"github.com/ikedam/cloudbuild/internal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List recent builds",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		initLevel()
+		list := &internal.CloudBuildList{}
+		if err := func() error {
+			if err := viper.Unmarshal(&list.Config); err != nil {
+				return internal.NewConfigError("Failed to parse configurations", err)
+			}
+			flags := cmd.Flags()
+			var err error
+			if list.Statuses, err = flags.GetStringSlice("status"); err != nil {
+				return err
+			}
+			if list.TriggerID, err = flags.GetString("trigger"); err != nil {
+				return err
+			}
+			if list.Tags, err = flags.GetStringSlice("tag"); err != nil {
+				return err
+			}
+			if list.Since, err = flags.GetString("since"); err != nil {
+				return err
+			}
+			if list.Until, err = flags.GetString("until"); err != nil {
+				return err
+			}
+			if list.Filter, err = flags.GetString("filter"); err != nil {
+				return err
+			}
+			if list.Limit, err = flags.GetInt("limit"); err != nil {
+				return err
+			}
+			if list.Output, err = flags.GetString("output"); err != nil {
+				return err
+			}
+			return list.Execute()
+		}(); err != nil {
+			exitForError(err, "Failed to list builds")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+
+	listCmd.Flags().StringSlice("status", []string{}, "Status of builds to list. Accepts multiple times.")
+	listCmd.Flags().String("trigger", "", "ID of the trigger started builds to list.")
+	listCmd.Flags().StringSlice("tag", []string{}, "Tag of builds to list. Accepts multiple times.")
+	listCmd.Flags().String("since", "", "List builds created after this. Duration like 24h or RFC3339 timestamp.")
+	listCmd.Flags().String("until", "", "List builds created before this. Duration like 24h or RFC3339 timestamp.")
+	listCmd.Flags().String("filter", "", "Filter expression of Cloud Build to list builds.")
+	listCmd.Flags().Int("limit", 50, "Maximum number of builds to list. 0 for unlimited.")
+	listCmd.Flags().StringP("output", "o", internal.OutputFormatTable, "Output format: table or json.")
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/ikedam/cloudbuild/internal"
+	"github.com/ikedam/cloudbuild/internal/signal"
+	"github.com/ikedam/cloudbuild/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// pipelineCmd represents the pipeline command
+var pipelineCmd = &cobra.Command{
+	Use:   "pipeline SOURCE_DIR",
+	Short: "Upload the source once and run dependent builds in a pipeline file",
+	Args:  cobra.ExactArgs(1),
+	Annotations: map[string]string{
+		annotationSourceDir: "true",
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindSourceFlags(cmd.Flags())
+		bindWatchFlags(cmd.Flags())
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		initLevel()
+		pipeline := &internal.CloudBuildSubmitMany{}
+		signal.WithSignalStacktrace(
+			viper.GetBool("alwaysDump"),
+			func() {
+				if err := func() error {
+					if err := viper.Unmarshal(&pipeline.Config); err != nil {
+						return internal.NewConfigError("Failed to parse configurations", err)
+					}
+					flags := cmd.Flags()
+					var err error
+					if pipeline.Config.Substitutions, err = flags.GetStringSlice("substitution"); err != nil {
+						return err
+					}
+					if pipeline.Concurrency, err = flags.GetInt("concurrency"); err != nil {
+						return err
+					}
+					file, err := flags.GetString("file")
+					if err != nil {
+						return err
+					}
+					if pipeline.Builds, err = internal.ReadBuildManifest(file); err != nil {
+						return err
+					}
+					if err := pipeline.Config.ResolveDefaults(); err != nil {
+						return err
+					}
+					pipeline.Config.SourceDir = args[0]
+					log.WithField("configuration", &pipeline.Config).Trace("Initialized configuration")
+
+					return pipeline.Execute()
+				}(); err != nil {
+					exitForError(err, "Failed to run the pipeline")
+				}
+			},
+			func(s os.Signal) {
+				if err := pipeline.Cancel(); err != nil {
+					log.WithError(err).Error("Failed to cancel builds.")
+				}
+			},
+		)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pipelineCmd)
+
+	flags := pipelineCmd.Flags()
+	addSourceFlags(flags)
+	flags.StringP("file", "f", "cloudbuild-pipeline.yaml", "Pipeline file listing builds and their dependencies.")
+	flags.StringSliceP("substitution", "s", []string{}, "key=value expression to replace keywords in all builds. Accepts multiple times.")
+	flags.Int("concurrency", 0, "Maximum number of builds running at the same time. 0 for no limit.")
+	addWatchFlags(flags)
+}
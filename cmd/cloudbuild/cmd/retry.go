@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/ikedam/cloudbuild/internal"
+	"github.com/ikedam/cloudbuild/internal/signal"
+	"github.com/ikedam/cloudbuild/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// retryCmd represents the retry command
+var retryCmd = &cobra.Command{
+	Use:   "retry BUILD_ID",
+	Short: "Retry a build and watch the new build",
+	Args:  cobra.ExactArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindWatchFlags(cmd.Flags())
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		initLevel()
+		retry := &internal.CloudBuildRetry{}
+		signal.WithSignalStacktrace(
+			viper.GetBool("alwaysDump"),
+			func() {
+				if err := func() error {
+					if err := viper.Unmarshal(&retry.Config); err != nil {
+						return internal.NewConfigError("Failed to parse configurations", err)
+					}
+					flags := cmd.Flags()
+					var err error
+					if retry.Config.Substitutions, err = flags.GetStringSlice("substitution"); err != nil {
+						return err
+					}
+					if retry.Recreate, err = flags.GetBool("recreate"); err != nil {
+						return err
+					}
+					retry.BuildID = args[0]
+					return retry.Execute()
+				}(); err != nil {
+					exitForError(err, "Failed to retry the build")
+				}
+			},
+			func(s os.Signal) {
+				if err := retry.Cancel(); err != nil {
+					log.WithError(err).Error("Failed to cancel build.")
+				}
+			},
+		)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(retryCmd)
+
+	retryCmd.Flags().StringSliceP("substitution", "s", []string{}, "key=value expression to override substitutions of the build. Accepts multiple times.")
+	addWatchFlags(retryCmd.Flags())
+	retryCmd.Flags().Bool("recreate", false, "Create a new build from the definition of the build instead of the retry API. Implied by --substitution.")
+}
@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/ikedam/cloudbuild/internal"
 	"github.com/ikedam/cloudbuild/internal/signal"
@@ -15,11 +18,42 @@ import (
 
 var cfgFile string
 
+// annotationSourceDir marks commands taking the source directory as the first argument.
+// Settings files are looked up from the source directory for them.
+const annotationSourceDir = "cloudbuild/source-dir"
+
+// settingsName is the name of settings files without the extension.
+const settingsName = ".cloudbuildconfig"
+
+// settingsLayer is settings read from a settings file or a profile.
+// These are kept to tell where settings come from.
+type settingsLayer struct {
+	name     string
+	settings *viper.Viper
+}
+
+// settingsLayers is layers of settings in the order of merging.
+var settingsLayers []*settingsLayer
+
+// userSettingsFile is the settings file in the home directory.
+// Files not existing yet can be set.
+var userSettingsFile string
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "cloudbuild",
 	Short: "cloudbuild is a client application for Google Cloud Build",
 	Args:  cobra.ExactValidArgs(1),
+	Annotations: map[string]string{
+		annotationSourceDir: "true",
+	},
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		sourceDir := "."
+		if _, ok := cmd.Annotations[annotationSourceDir]; ok && len(args) > 0 {
+			sourceDir = args[0]
+		}
+		initConfig(cmd, sourceDir)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		initLevel()
 		submit := &internal.CloudBuildSubmit{}
@@ -48,16 +82,7 @@ var rootCmd = &cobra.Command{
 
 					return submit.Execute()
 				}(); err != nil {
-					var buildResultError *internal.BuildResultError
-					if xerrors.As(err, &buildResultError) {
-						log.WithError(err).
-							WithField("buildID", buildResultError.BuildID).
-							WithField("status", buildResultError.Status).
-							Error("Build failed")
-					} else {
-						log.WithError(err).Error("Failed to run a build")
-					}
-					log.Exit(internal.ExitCodeForError(err))
+					exitForError(err, "Failed to run a build")
 				}
 			},
 			func(s os.Signal) {
@@ -69,6 +94,30 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// exitForError logs the error and exits with the exit code for the error.
+func exitForError(err error, message string) {
+	var skippedError *internal.SkippedError
+	var buildResultError *internal.BuildResultError
+	if xerrors.As(err, &skippedError) {
+		log.WithField("reason", skippedError.Reason).Info("Build skipped")
+	} else if xerrors.As(err, &buildResultError) {
+		entry := log.WithError(err).
+			WithField("buildID", buildResultError.BuildID).
+			WithField("status", buildResultError.Status)
+		if len(buildResultError.FailedSteps) > 0 {
+			entry = entry.WithField("failedSteps", buildResultError.FailedSteps)
+		}
+		if buildResultError.FailureInfo != nil {
+			entry = entry.WithField("failureType", buildResultError.FailureInfo.Type).
+				WithField("failureDetail", buildResultError.FailureInfo.Detail)
+		}
+		entry.Error("Build failed")
+	} else {
+		log.WithError(err).Error(message)
+	}
+	log.Exit(internal.ExitCodeForError(err))
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -80,20 +129,33 @@ func Execute() {
 
 func init() {
 	cobra.OnInitialize(initLevel)
-	cobra.OnInitialize(initConfig)
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.cloudbuild.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "settings", "", "Settings file to read over other settings files.")
+	rootCmd.PersistentFlags().String("profile", "", "Name of the profile in settings files to use.")
+	viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
 	rootCmd.PersistentFlags().String("log-level", "info", "Log level.")
 	viper.BindPFlag("logLevel", rootCmd.PersistentFlags().Lookup("log-level"))
+	rootCmd.PersistentFlags().String("log-format", log.FormatText, "Log format: text, json or logfmt.")
+	viper.BindPFlag("logFormat", rootCmd.PersistentFlags().Lookup("log-format"))
+	rootCmd.PersistentFlags().String("log-output", log.OutputStderr, "Destination of logs: stderr or a path of a file to append logs to.")
+	viper.BindPFlag("logOutput", rootCmd.PersistentFlags().Lookup("log-output"))
 	rootCmd.PersistentFlags().Bool("always-dump", false, "Print stack dump also for SIGHUP, SIGINT, and SIGTERM")
 	viper.BindPFlag("alwaysDump", rootCmd.PersistentFlags().Lookup("always-dump"))
+	rootCmd.PersistentFlags().StringSlice("sensitive-key-pattern", log.DefaultSensitiveKeyPatterns, "Pattern of keys like *TOKEN* whose values are redacted in logs. Accepts multiple times.")
+	viper.BindPFlag("sensitiveKeyPatterns", rootCmd.PersistentFlags().Lookup("sensitive-key-pattern"))
 
-	rootCmd.Flags().String("project", "", "ID of Google Cloud Project.")
-	viper.BindPFlag("project", rootCmd.Flags().Lookup("project"))
+	rootCmd.PersistentFlags().String("project", "", "ID of Google Cloud Project.")
+	viper.BindPFlag("project", rootCmd.PersistentFlags().Lookup("project"))
+	rootCmd.PersistentFlags().String("credentials-file", "", "JSON credentials file to use instead of the application default credentials.")
+	viper.BindPFlag("credentialsFile", rootCmd.PersistentFlags().Lookup("credentials-file"))
+	rootCmd.PersistentFlags().String("access-token-file", "", "File containing an OAuth2 access token to use. Read every time a token is required.")
+	viper.BindPFlag("accessTokenFile", rootCmd.PersistentFlags().Lookup("access-token-file"))
+	rootCmd.PersistentFlags().String("impersonate-service-account", "", "Service account to impersonate.")
+	viper.BindPFlag("impersonateServiceAccount", rootCmd.PersistentFlags().Lookup("impersonate-service-account"))
 	rootCmd.Flags().String("gcs-source-staging-dir", "", "GCS directory to store source archives.")
 	viper.BindPFlag("gcsSourceStagingDir", rootCmd.Flags().Lookup("gcs-source-staging-dir"))
 	rootCmd.Flags().String("ignore-file", ".gcloudignore", "File to use instead of .gcloudignore. Can be relative to the source directory.")
@@ -104,14 +166,33 @@ func init() {
 	viper.BindPFlag("substitutions", rootCmd.Flags().Lookup("substitution"))
 	// for compatibility with `gcloud builds submit`
 	rootCmd.Flags().String("substitutions", "", "comma-separated key=value expressions to replace keywords in cloudbuild.yaml.")
+	rootCmd.Flags().String("changed-since", "", "git ref to skip the build if no relevant files changed since. Requires the git command.")
+	viper.BindPFlag("changedSince", rootCmd.Flags().Lookup("changed-since"))
+	rootCmd.Flags().String("service-account", "", "Service account to run the build as. Email or projects/PROJECT/serviceAccounts/EMAIL.")
+	viper.BindPFlag("serviceAccount", rootCmd.Flags().Lookup("service-account"))
+	rootCmd.Flags().String("trace-endpoint", "", "OTLP/HTTP endpoint like http://localhost:4318 to export traces of the submission to.")
+	viper.BindPFlag("traceEndpoint", rootCmd.Flags().Lookup("trace-endpoint"))
+	rootCmd.Flags().String("metrics-file", "", "File to write metrics of the submission to in OpenMetrics text format. Suitable for the textfile collector of node exporter.")
+	viper.BindPFlag("metricsFile", rootCmd.Flags().Lookup("metrics-file"))
+	rootCmd.Flags().String("pushgateway", "", "URL of Prometheus Pushgateway like http://localhost:9091 to push metrics of the submission to.")
+	viper.BindPFlag("pushgateway", rootCmd.Flags().Lookup("pushgateway"))
+	rootCmd.Flags().StringSlice("secret", []string{}, "NAME=VERSION of a Secret Manager secret to make available to steps as secretEnv. VERSION can be a name of a secret for the latest version. Accepts multiple times.")
+	viper.BindPFlag("secrets", rootCmd.Flags().Lookup("secret"))
+	rootCmd.Flags().StringSlice("sensitive-substitution", []string{}, "Key of a substitution to mask its value in logs. Accepts multiple times.")
+	viper.BindPFlag("sensitiveSubstitutions", rootCmd.Flags().Lookup("sensitive-substitution"))
+	addWatchFlags(rootCmd.Flags())
+	bindWatchFlags(rootCmd.Flags())
+
+	for _, setting := range internal.DurationSettings {
+		flag := settingFlags[setting.Key]
+		rootCmd.PersistentFlags().Duration(flag, setting.Default, durationFlagUsages[setting.Key])
+		viper.BindPFlag(setting.Key, rootCmd.PersistentFlags().Lookup(flag))
+		viper.SetDefault(setting.Key, setting.Default)
+	}
 
-	viper.SetDefault("pollingIntervalMsec", 1000)
-	viper.SetDefault("uploadTimeoutMsec", 5*60*1000)
 	viper.SetDefault("maxUploadTryCount", 5)
-	viper.SetDefault("cloudBuildTimeoutMsec", 10*1000)
 	viper.SetDefault("maxStartBuildTryCount", 5)
 	viper.SetDefault("maxGetBuildTryCount", 100)
-	viper.SetDefault("readLogTimeoutMsec", 30*1000)
 	viper.SetDefault("maxReadLogTryCount", 100)
 }
 
@@ -121,51 +202,189 @@ func initLevel() {
 		log.WithError(err).Error("Invalid log level specified.")
 		log.Exit(internal.ExitCodeConfigurationError)
 	}
+	if err := log.SetFormatByName(viper.GetString("logFormat")); err != nil {
+		log.WithError(err).Error("Invalid log format specified.")
+		log.Exit(internal.ExitCodeConfigurationError)
+	}
+	if err := log.SetOutputByName(viper.GetString("logOutput")); err != nil {
+		log.WithError(err).Error("Invalid log output specified.")
+		log.Exit(internal.ExitCodeConfigurationError)
+	}
+	if err := log.SetSensitiveKeyPatterns(viper.GetStringSlice("sensitiveKeyPatterns")); err != nil {
+		log.WithError(err).Error("Invalid sensitive key patterns specified.")
+		log.Exit(internal.ExitCodeConfigurationError)
+	}
 }
 
-// initConfig reads in config file and ENV variables if set.
-func initConfig() {
-	if cfgFile != "" {
-		// Use config file from the flag.
-		viper.SetConfigFile(cfgFile)
+// initConfig reads in settings files and ENV variables if set.
+// Settings files are merged in this order, the latter overriding the former:
+//
+// 1. /etc/cloudbuild/config.yaml
+// 2. .cloudbuildconfig.yaml in the home directory
+// 3. .cloudbuildconfig.yaml in the source directory or its nearest parent
+// 4. The file specified with --settings
+//
+// The profile specified with --profile overrides them,
+// and environment variables and command line options override all of them.
+func initConfig(cmd *cobra.Command, sourceDir string) {
+	viper.SetEnvPrefix("CLOUDBUILD")
+	viper.AutomaticEnv() // read in environment variables that match
+
+	files := []string{}
+	if globalFile := "/etc/cloudbuild/config.yaml"; fileExists(globalFile) {
+		files = append(files, globalFile)
+	}
+
+	// Find home directory.
+	home, err := homedir.Dir()
+	if err != nil {
+		log.WithError(err).Error("Failed to stat home directory.")
+		log.Exit(internal.ExitCodeConfigurationError)
+	}
+	homeFile := findSettingsFile(home)
+	if homeFile != "" {
+		files = append(files, homeFile)
+		userSettingsFile = homeFile
 	} else {
-		// Find home directory.
-		home, err := homedir.Dir()
-		if err != nil {
-			log.WithError(err).Error("Failed to stat home directory.")
+		userSettingsFile = filepath.Join(home, settingsName+".yaml")
+	}
+
+	if projectFile := findProjectSettingsFile(sourceDir); projectFile != "" && projectFile != homeFile {
+		files = append(files, projectFile)
+	}
+
+	if cfgFile != "" {
+		files = append(files, cfgFile)
+	}
+
+	for _, file := range files {
+		if err := mergeSettingsFile(file); err != nil {
+			log.WithError(err).WithField("file", file).Error("Failed to read settings")
 			log.Exit(internal.ExitCodeConfigurationError)
 		}
+		log.WithField("file", file).Debug("Read settings")
+	}
 
-		// Search config in home directory with name ".cloudbuildconfig" (without extension).
-		viper.AddConfigPath(home)
-		viper.SetConfigName(".cloudbuildconfig")
+	if err := applyProfile(viper.GetString("profile")); err != nil {
+		log.WithError(err).Error("Failed to apply the profile")
+		log.Exit(internal.ExitCodeConfigurationError)
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
-	viper.SetEnvPrefix("CLOUDBUILD")
+	applyDeprecatedSettings(cmd)
+	for _, setting := range internal.DurationSettings {
+		if err := setting.Check(viper.GetDuration(setting.Key)); err != nil {
+			log.WithError(err).Error("Invalid settings")
+			log.Exit(internal.ExitCodeConfigurationError)
+		}
+	}
+}
+
+// applyDeprecatedSettings converts settings in deprecated keys to current ones.
+// Settings in current keys take precedence.
+func applyDeprecatedSettings(cmd *cobra.Command) {
+	for _, setting := range internal.DurationSettings {
+		source := explicitSettingSource(cmd, setting.DeprecatedMsecKey)
+		if source == "" {
+			continue
+		}
+		log.WithField("key", setting.DeprecatedMsecKey).
+			WithField("source", source).
+			Warningf("%v is deprecated. Use %v instead, e.g. %v: 30s", setting.DeprecatedMsecKey, setting.Key, setting.Key)
+		if explicitSettingSource(cmd, setting.Key) != "" {
+			continue
+		}
+		viper.Set(setting.Key, time.Duration(viper.GetInt(setting.DeprecatedMsecKey))*time.Millisecond)
+	}
+}
+
+// findSettingsFile returns the settings file in the directory.
+// Returns an empty string if not found.
+func findSettingsFile(dir string) string {
+	for _, ext := range viper.SupportedExts {
+		file := filepath.Join(dir, fmt.Sprintf("%v.%v", settingsName, ext))
+		if fileExists(file) {
+			return file
+		}
+	}
+	return ""
+}
 
-	// Read /etc/cloudbuild/config.yaml
-	globalFile := "/etc/cloudbuild/config.yaml"
-	if _, err := os.Stat(globalFile); err == nil {
-		func() {
-			log.WithField("file", globalFile).Debug("reading global config")
-			fd, err := os.Open(globalFile)
-			if err != nil {
-				log.WithError(err).WithField("file", globalFile).Warning("Failed to open global config")
-				return
-			}
-			defer fd.Close()
-			if err := viper.ReadConfig(fd); err != nil {
-				log.WithError(err).WithField("file", globalFile).Warning("Failed to read global config")
-				return
-			}
-		}()
-	}
-
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
-		log.WithField("configfile", viper.ConfigFileUsed).Trace("Using config file")
+// findProjectSettingsFile returns the settings file in the source directory
+// or its nearest parent. Returns an empty string if not found.
+func findProjectSettingsFile(sourceDir string) string {
+	dir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		log.WithError(err).WithField("dir", sourceDir).Debug("Failed to resolve the source directory")
+		return ""
 	}
+	for {
+		if file := findSettingsFile(dir); file != "" {
+			return file
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// mergeSettingsFile merges the settings file into the current settings.
+func mergeSettingsFile(file string) error {
+	settings, err := readSettingsFile(file)
+	if err != nil {
+		return err
+	}
+	settingsLayers = append(settingsLayers, &settingsLayer{
+		name:     file,
+		settings: settings,
+	})
+	return viper.MergeConfigMap(settings.AllSettings())
+}
+
+// readSettingsFile reads the settings file.
+func readSettingsFile(file string) (*viper.Viper, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	configType := strings.TrimPrefix(filepath.Ext(file), ".")
+	if configType == "" {
+		configType = "yaml"
+	}
+	settings := viper.New()
+	settings.SetConfigType(configType)
+	if err := settings.ReadConfig(fd); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// applyProfile overrides settings with ones in `profiles.NAME`.
+func applyProfile(profile string) error {
+	if profile == "" {
+		return nil
+	}
+	key := fmt.Sprintf("profiles.%v", profile)
+	if !viper.IsSet(key) {
+		return xerrors.Errorf("Unknown profile %v", profile)
+	}
+	log.WithField("profile", profile).Debug("Using profile")
+	settings := viper.New()
+	if err := settings.MergeConfigMap(viper.GetStringMap(key)); err != nil {
+		return err
+	}
+	settingsLayers = append(settingsLayers, &settingsLayer{
+		name:     fmt.Sprintf("profile %v", profile),
+		settings: settings,
+	})
+	return viper.MergeConfigMap(settings.AllSettings())
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
 }
 
 // SetVersion sets version of the command
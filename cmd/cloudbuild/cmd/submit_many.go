@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/ikedam/cloudbuild/internal"
+	"github.com/ikedam/cloudbuild/internal/signal"
+	"github.com/ikedam/cloudbuild/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// submitManyCmd represents the submit-many command
+var submitManyCmd = &cobra.Command{
+	Use:   "submit-many SOURCE_DIR",
+	Short: "Upload the source once and run multiple builds in parallel",
+	Args:  cobra.ExactArgs(1),
+	Annotations: map[string]string{
+		annotationSourceDir: "true",
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindSourceFlags(cmd.Flags())
+		bindWatchFlags(cmd.Flags())
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		initLevel()
+		submitMany := &internal.CloudBuildSubmitMany{}
+		signal.WithSignalStacktrace(
+			viper.GetBool("alwaysDump"),
+			func() {
+				if err := func() error {
+					if err := viper.Unmarshal(&submitMany.Config); err != nil {
+						return internal.NewConfigError("Failed to parse configurations", err)
+					}
+					flags := cmd.Flags()
+					var err error
+					if submitMany.Config.Substitutions, err = flags.GetStringSlice("substitution"); err != nil {
+						return err
+					}
+					if submitMany.Concurrency, err = flags.GetInt("concurrency"); err != nil {
+						return err
+					}
+					manifest, err := flags.GetString("manifest")
+					if err != nil {
+						return err
+					}
+					if manifest != "" {
+						if submitMany.Builds, err = internal.ReadBuildManifest(manifest); err != nil {
+							return err
+						}
+					}
+					configs, err := flags.GetStringSlice("config")
+					if err != nil {
+						return err
+					}
+					for _, config := range configs {
+						submitMany.Builds = append(submitMany.Builds, &internal.BuildSpec{Config: config})
+					}
+					matrix, err := flags.GetString("matrix")
+					if err != nil {
+						return err
+					}
+					if matrix != "" {
+						if submitMany.Matrix, err = internal.ReadBuildMatrix(matrix); err != nil {
+							return err
+						}
+					}
+					axes, err := flags.GetStringArray("axis")
+					if err != nil {
+						return err
+					}
+					for _, axis := range axes {
+						if submitMany.Matrix == nil {
+							submitMany.Matrix = &internal.BuildMatrix{}
+						}
+						if err := submitMany.Matrix.AddAxis(axis); err != nil {
+							return err
+						}
+					}
+					if len(submitMany.Builds) == 0 && submitMany.Matrix != nil {
+						// Run the matrix for cloudbuild.yaml by default.
+						submitMany.Builds = append(submitMany.Builds, &internal.BuildSpec{Config: submitMany.Config.Config})
+					}
+					if err := submitMany.Config.ResolveDefaults(); err != nil {
+						return err
+					}
+					submitMany.Config.SourceDir = args[0]
+					log.WithField("configuration", &submitMany.Config).Trace("Initialized configuration")
+
+					return submitMany.Execute()
+				}(); err != nil {
+					exitForError(err, "Failed to run builds")
+				}
+			},
+			func(s os.Signal) {
+				if err := submitMany.Cancel(); err != nil {
+					log.WithError(err).Error("Failed to cancel builds.")
+				}
+			},
+		)
+	},
+}
+
+// addSourceFlags adds flags for subcommands uploading the source directory.
+func addSourceFlags(flags *pflag.FlagSet) {
+	flags.String("gcs-source-staging-dir", "", "GCS directory to store source archives.")
+	flags.String("ignore-file", ".gcloudignore", "File to use instead of .gcloudignore. Can be relative to the source directory.")
+	flags.String("changed-since", "", "git ref to skip builds if no relevant files changed since. Requires the git command.")
+	flags.String("trace-endpoint", "", "OTLP/HTTP endpoint like http://localhost:4318 to export traces of the submission to.")
+	flags.String("metrics-file", "", "File to write metrics of the submission to in OpenMetrics text format. Suitable for the textfile collector of node exporter.")
+	flags.String("pushgateway", "", "URL of Prometheus Pushgateway like http://localhost:9091 to push metrics of the submission to.")
+	flags.String("service-account", "", "Service account to run builds as. Email or projects/PROJECT/serviceAccounts/EMAIL.")
+	flags.StringSlice("secret", []string{}, "NAME=VERSION of a Secret Manager secret to make available to steps as secretEnv. VERSION can be a name of a secret for the latest version. Accepts multiple times.")
+	flags.StringSlice("sensitive-substitution", []string{}, "Key of a substitution to mask its value in logs. Accepts multiple times.")
+}
+
+// bindSourceFlags binds flags added with addSourceFlags to configurations.
+func bindSourceFlags(flags *pflag.FlagSet) {
+	viper.BindPFlag("gcsSourceStagingDir", flags.Lookup("gcs-source-staging-dir"))
+	viper.BindPFlag("ignoreFile", flags.Lookup("ignore-file"))
+	viper.BindPFlag("changedSince", flags.Lookup("changed-since"))
+	viper.BindPFlag("serviceAccount", flags.Lookup("service-account"))
+	viper.BindPFlag("traceEndpoint", flags.Lookup("trace-endpoint"))
+	viper.BindPFlag("metricsFile", flags.Lookup("metrics-file"))
+	viper.BindPFlag("pushgateway", flags.Lookup("pushgateway"))
+	viper.BindPFlag("secrets", flags.Lookup("secret"))
+	viper.BindPFlag("sensitiveSubstitutions", flags.Lookup("sensitive-substitution"))
+}
+
+func init() {
+	rootCmd.AddCommand(submitManyCmd)
+
+	flags := submitManyCmd.Flags()
+	addSourceFlags(flags)
+	flags.String("manifest", "", "YAML file listing builds to run.")
+	flags.StringSliceP("config", "c", []string{}, "cloudbuild.yaml of a build to run. Accepts multiple times.")
+	flags.StringSliceP("substitution", "s", []string{}, "key=value expression to replace keywords in all builds. Accepts multiple times.")
+	flags.String("matrix", "", "YAML file of the build matrix to run each build for combinations of substitutions.")
+	flags.StringArray("axis", []string{}, "KEY=VALUE1,VALUE2,... axis of the build matrix. Accepts multiple times.")
+	flags.Int("concurrency", 4, "Maximum number of builds running at the same time. 0 for no limit.")
+	addWatchFlags(flags)
+}
@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/ikedam/cloudbuild/internal"
+	"github.com/ikedam/cloudbuild/internal/signal"
+	"github.com/ikedam/cloudbuild/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// triggersCmd represents the triggers command
+var triggersCmd = &cobra.Command{
+	Use:   "triggers",
+	Short: "Manage build triggers",
+}
+
+// triggersListCmd represents the triggers list command
+var triggersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List build triggers",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		initLevel()
+		list := &internal.CloudBuildTriggerList{}
+		if err := func() error {
+			if err := viper.Unmarshal(&list.Config); err != nil {
+				return internal.NewConfigError("Failed to parse configurations", err)
+			}
+			var err error
+			if list.Output, err = cmd.Flags().GetString("output"); err != nil {
+				return err
+			}
+			return list.Execute()
+		}(); err != nil {
+			exitForError(err, "Failed to list triggers")
+		}
+	},
+}
+
+// triggersDescribeCmd represents the triggers describe command
+var triggersDescribeCmd = &cobra.Command{
+	Use:   "describe TRIGGER",
+	Short: "Show details of a build trigger",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		initLevel()
+		describe := &internal.CloudBuildTriggerDescribe{}
+		if err := func() error {
+			if err := viper.Unmarshal(&describe.Config); err != nil {
+				return internal.NewConfigError("Failed to parse configurations", err)
+			}
+			var err error
+			if describe.Output, err = cmd.Flags().GetString("output"); err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("sensitive-substitution") {
+				if describe.Config.SensitiveSubstitutions, err = cmd.Flags().GetStringSlice("sensitive-substitution"); err != nil {
+					return err
+				}
+			}
+			describe.Trigger = args[0]
+			return describe.Execute()
+		}(); err != nil {
+			exitForError(err, "Failed to describe the trigger")
+		}
+	},
+}
+
+// triggersRunCmd represents the triggers run command
+var triggersRunCmd = &cobra.Command{
+	Use:   "run TRIGGER",
+	Short: "Run a build trigger and watch the started build",
+	Args:  cobra.ExactArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindWatchFlags(cmd.Flags())
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		initLevel()
+		run := &internal.CloudBuildTriggerRun{}
+		signal.WithSignalStacktrace(
+			viper.GetBool("alwaysDump"),
+			func() {
+				if err := func() error {
+					if err := viper.Unmarshal(&run.Config); err != nil {
+						return internal.NewConfigError("Failed to parse configurations", err)
+					}
+					flags := cmd.Flags()
+					var err error
+					if run.Config.Substitutions, err = flags.GetStringSlice("substitution"); err != nil {
+						return err
+					}
+					if run.Branch, err = flags.GetString("branch"); err != nil {
+						return err
+					}
+					if run.Tag, err = flags.GetString("tag"); err != nil {
+						return err
+					}
+					if run.CommitSha, err = flags.GetString("sha"); err != nil {
+						return err
+					}
+					run.Trigger = args[0]
+					return run.Execute()
+				}(); err != nil {
+					exitForError(err, "Failed to run the trigger")
+				}
+			},
+			func(s os.Signal) {
+				if err := run.Cancel(); err != nil {
+					log.WithError(err).Error("Failed to cancel build.")
+				}
+			},
+		)
+	},
+}
+
+// triggersExportCmd represents the triggers export command
+var triggersExportCmd = &cobra.Command{
+	Use:   "export [TRIGGER...]",
+	Short: "Export build triggers to YAML files",
+	Args:  cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		initLevel()
+		export := &internal.CloudBuildTriggerExport{}
+		if err := func() error {
+			if err := viper.Unmarshal(&export.Config); err != nil {
+				return internal.NewConfigError("Failed to parse configurations", err)
+			}
+			var err error
+			if export.Dir, err = cmd.Flags().GetString("dir"); err != nil {
+				return err
+			}
+			export.Triggers = args
+			return export.Execute()
+		}(); err != nil {
+			exitForError(err, "Failed to export triggers")
+		}
+	},
+}
+
+// triggersApplyCmd represents the triggers apply command
+var triggersApplyCmd = &cobra.Command{
+	Use:   "apply FILE...",
+	Short: "Create or update build triggers from YAML files",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		initLevel()
+		apply := &internal.CloudBuildTriggerApply{}
+		if err := func() error {
+			if err := viper.Unmarshal(&apply.Config); err != nil {
+				return internal.NewConfigError("Failed to parse configurations", err)
+			}
+			var err error
+			if apply.DryRun, err = cmd.Flags().GetBool("dry-run"); err != nil {
+				return err
+			}
+			apply.Files = args
+			return apply.Execute()
+		}(); err != nil {
+			exitForError(err, "Failed to apply triggers")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(triggersCmd)
+	triggersCmd.AddCommand(triggersListCmd)
+	triggersCmd.AddCommand(triggersDescribeCmd)
+	triggersCmd.AddCommand(triggersRunCmd)
+	triggersCmd.AddCommand(triggersExportCmd)
+	triggersCmd.AddCommand(triggersApplyCmd)
+
+	triggersListCmd.Flags().StringP("output", "o", internal.OutputFormatTable, "Output format: table or json.")
+
+	triggersDescribeCmd.Flags().StringP("output", "o", internal.OutputFormatYAML, "Output format: yaml or json.")
+	triggersDescribeCmd.Flags().StringSlice("sensitive-substitution", []string{}, "Key of a substitution to mask its value. Accepts multiple times.")
+
+	triggersRunCmd.Flags().String("branch", "", "Branch to build.")
+	triggersRunCmd.Flags().String("tag", "", "Tag to build.")
+	triggersRunCmd.Flags().String("sha", "", "Commit sha to build.")
+	triggersRunCmd.Flags().StringSliceP("substitution", "s", []string{}, "key=value expression to override substitutions of the trigger. Accepts multiple times.")
+	addWatchFlags(triggersRunCmd.Flags())
+
+	triggersExportCmd.Flags().String("dir", ".", "Directory to write YAML files.")
+
+	triggersApplyCmd.Flags().Bool("dry-run", false, "Print differences without applying them.")
+}
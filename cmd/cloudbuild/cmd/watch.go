@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// watchFlags maps flags for watching builds to configuration keys.
+var watchFlags = []struct {
+	flag string
+	key  string
+}{
+	{"log-file", "logFile"},
+	{"quiet-build-log", "quietBuildLog"},
+	{"color-build-log", "colorBuildLog"},
+	{"build-log-timestamps", "buildLogTimestamps"},
+	{"step", "steps"},
+	{"failed-step-log-lines", "failedStepLogLines"},
+	{"ci-integration", "ciIntegration"},
+	{"ci-dotenv-file", "ciDotenvFile"},
+	{"junit-report", "junitReport"},
+}
+
+// addWatchFlags adds flags for commands watching builds.
+func addWatchFlags(flags *pflag.FlagSet) {
+	flags.String("log-file", "", "File to save the build log to.")
+	flags.Bool("quiet-build-log", false, "Don't print the build log to the console.")
+	flags.Bool("color-build-log", false, "Colorize prefixes of steps in the build log.")
+	flags.Bool("build-log-timestamps", false, "Prefix lines of the build log with the time received.")
+	flags.StringSlice("step", []string{}, "id or index of the step to print the build log. Accepts multiple times.")
+	flags.Int("failed-step-log-lines", 0, "Number of last lines of logs of failed steps to print again when the build failed.")
+	flags.String("ci-integration", "", "CI service to integrate with: auto, github or gitlab.")
+	flags.String("ci-dotenv-file", "cloudbuild.env", "dotenv file to write the build result for GitLab CI/CD.")
+	flags.String("junit-report", "", "File to write the JUnit XML report of steps to.")
+}
+
+// bindWatchFlags binds flags added with addWatchFlags to configurations.
+// Subcommands call this when they run as flags of only one command can be bound to a key.
+func bindWatchFlags(flags *pflag.FlagSet) {
+	for _, watchFlag := range watchFlags {
+		viper.BindPFlag(watchFlag.key, flags.Lookup(watchFlag.flag))
+	}
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"github.com/ikedam/cloudbuild/internal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// whoamiCmd represents the whoami command
+var whoamiCmd = &cobra.Command{
+	Use:   "whoami",
+	Short: "Show the account and the project used to access Google Cloud",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		initLevel()
+		whoami := &internal.CloudBuildWhoami{}
+		if err := func() error {
+			if err := viper.Unmarshal(&whoami.Config); err != nil {
+				return internal.NewConfigError("Failed to parse configurations", err)
+			}
+			return whoami.Execute()
+		}(); err != nil {
+			exitForError(err, "Failed to get the account")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(whoamiCmd)
+}
@@ -0,0 +1,306 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/xerrors"
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+	"google.golang.org/api/impersonate"
+	logging "google.golang.org/api/logging/v2"
+	"google.golang.org/api/option"
+
+	"github.com/ikedam/cloudbuild/log"
+)
+
+// cloudPlatformScope is the OAuth2 scope to access Google Cloud Platform services.
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// authCredentials is the credentials to access Google Cloud Platform services.
+type authCredentials struct {
+	// tokenSource provides access tokens.
+	tokenSource oauth2.TokenSource
+	// projectID is the project of the credentials. Can be empty.
+	projectID string
+	// source describes where the credentials come from.
+	source string
+	// principal is the account of the credentials if known without requests.
+	principal string
+}
+
+// credentialsCache holds the credentials resolved for the config.
+// Copies of the config share it.
+type credentialsCache struct {
+	mutex sync.Mutex
+	creds *authCredentials
+}
+
+// credentialsCacheMutex guards initializations of credentialsCache of configs.
+var credentialsCacheMutex sync.Mutex
+
+// shareCredentials prepares the cache of credentials
+// so that copies of the config made after this share credentials resolved later.
+func (c *Config) shareCredentials() *credentialsCache {
+	credentialsCacheMutex.Lock()
+	defer credentialsCacheMutex.Unlock()
+	if c.credentialsCache == nil {
+		c.credentialsCache = &credentialsCache{}
+	}
+	return c.credentialsCache
+}
+
+// credentials returns the credentials configured with CredentialsFile, AccessTokenFile
+// and ImpersonateServiceAccount, or the application default credentials.
+// This is the only place to decide credentials of the command.
+// Credentials are resolved only once for the config as it may take requests
+// like finding the application default credentials.
+func (c *Config) credentials(ctx context.Context) (*authCredentials, error) {
+	cache := c.shareCredentials()
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.creds != nil {
+		return cache.creds, nil
+	}
+	creds, err := c.resolveCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cache.creds = creds
+	return creds, nil
+}
+
+func (c *Config) resolveCredentials(ctx context.Context) (*authCredentials, error) {
+	if c.AccessTokenFile != "" && c.CredentialsFile != "" {
+		return nil, NewConfigError("Cannot specify both accessTokenFile and credentialsFile", nil)
+	}
+	creds := &authCredentials{}
+	switch {
+	case c.AccessTokenFile != "":
+		if _, err := readAccessToken(c.AccessTokenFile); err != nil {
+			return nil, NewConfigError(fmt.Sprintf("Failed to read access token %v", c.AccessTokenFile), err)
+		}
+		creds.tokenSource = &fileTokenSource{file: c.AccessTokenFile}
+		creds.source = fmt.Sprintf("access token file %v", c.AccessTokenFile)
+	case c.CredentialsFile != "":
+		body, err := ioutil.ReadFile(c.CredentialsFile)
+		if err != nil {
+			return nil, NewConfigError(fmt.Sprintf("Failed to read credentials %v", c.CredentialsFile), err)
+		}
+		googleCreds, err := google.CredentialsFromJSON(ctx, body, cloudPlatformScope)
+		if err != nil {
+			return nil, NewConfigError(fmt.Sprintf("Failed to parse credentials %v", c.CredentialsFile), err)
+		}
+		creds.tokenSource = googleCreds.TokenSource
+		creds.projectID = googleCreds.ProjectID
+		creds.source = fmt.Sprintf("credentials file %v", c.CredentialsFile)
+		creds.principal = credentialsEmail(body)
+	default:
+		googleCreds, err := google.FindDefaultCredentials(ctx, cloudPlatformScope)
+		if err != nil {
+			return nil, NewConfigError("Failed to get default credentials", err)
+		}
+		creds.tokenSource = googleCreds.TokenSource
+		creds.projectID = googleCreds.ProjectID
+		creds.source = "application default credentials"
+		creds.principal = credentialsEmail(googleCreds.JSON)
+	}
+
+	if c.ImpersonateServiceAccount != "" {
+		tokenSource, err := impersonate.CredentialsTokenSource(
+			ctx,
+			impersonate.CredentialsConfig{
+				TargetPrincipal: c.ImpersonateServiceAccount,
+				Scopes:          []string{cloudPlatformScope},
+			},
+			option.WithTokenSource(creds.tokenSource),
+		)
+		if err != nil {
+			return nil, NewConfigError(
+				fmt.Sprintf("Failed to impersonate %v", c.ImpersonateServiceAccount),
+				err,
+			)
+		}
+		creds.tokenSource = tokenSource
+		creds.source = fmt.Sprintf("%v impersonating %v", creds.source, c.ImpersonateServiceAccount)
+		creds.principal = c.ImpersonateServiceAccount
+	}
+	return creds, nil
+}
+
+// clientOptions returns options for clients of Google Cloud Platform services.
+func (c *Config) clientOptions(ctx context.Context) ([]option.ClientOption, error) {
+	creds, err := c.credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	opts := []option.ClientOption{
+		option.WithTokenSource(creds.tokenSource),
+	}
+	return append(opts, c.extraClientOptions...), nil
+}
+
+// newCloudBuildService creates a client of Cloud Build.
+func (c *Config) newCloudBuildService(ctx context.Context) (*cloudbuild.Service, error) {
+	opts, err := c.clientOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	service, err := cloudbuild.NewService(ctx, opts...)
+	if err != nil {
+		return nil, NewServiceError("Failed to create cloudbuild service", err)
+	}
+	return service, nil
+}
+
+// newStorageClient creates a client of Cloud Storage.
+func (c *Config) newStorageClient(ctx context.Context) (*storage.Client, error) {
+	opts, err := c.clientOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, NewServiceError("Failed to initialize gcs client", err)
+	}
+	return client, nil
+}
+
+// newLoggingService creates a client of Cloud Logging.
+func (c *Config) newLoggingService(ctx context.Context) (*logging.Service, error) {
+	opts, err := c.clientOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	service, err := logging.NewService(ctx, opts...)
+	if err != nil {
+		return nil, NewServiceError("Failed to create logging service", err)
+	}
+	return service, nil
+}
+
+// fileTokenSource provides the access token in the file.
+// The file is read every time as it can be updated by others
+// like workload identity federation of CI services.
+type fileTokenSource struct {
+	file string
+}
+
+func (s *fileTokenSource) Token() (*oauth2.Token, error) {
+	token, err := readAccessToken(s.file)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{
+		AccessToken: token,
+		TokenType:   "Bearer",
+	}, nil
+}
+
+func readAccessToken(file string) (string, error) {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(body))
+	if token == "" {
+		return "", xerrors.Errorf("%v is empty", file)
+	}
+	return token, nil
+}
+
+// credentialsEmail returns client_email in JSON credentials.
+// Returns an empty string if not available.
+func credentialsEmail(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var f struct {
+		ClientEmail string `json:"client_email"`
+	}
+	if err := json.Unmarshal(body, &f); err != nil {
+		return ""
+	}
+	return f.ClientEmail
+}
+
+// tokenInfoURL is the endpoint to get the information of access tokens.
+const tokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+
+// tokenEmail asks the account of the access token to Google.
+// The token is posted in the body not to be logged as a part of the URL.
+func tokenEmail(ctx context.Context, token *oauth2.Token) (string, error) {
+	req, err := http.NewRequest(
+		http.MethodPost,
+		tokenInfoURL,
+		strings.NewReader(url.Values{"access_token": {token.AccessToken}}.Encode()),
+	)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", xerrors.Errorf("Failed to get the token info: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", xerrors.Errorf("Failed to get the token info: %v", resp.Status)
+	}
+	var info struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", xerrors.Errorf("Failed to parse the token info: %w", err)
+	}
+	return info.Email, nil
+}
+
+// CloudBuildWhoami holds the state to show the effective principal
+type CloudBuildWhoami struct {
+	Config
+}
+
+// Execute prints the principal, the source of the credentials and the project.
+func (w *CloudBuildWhoami) Execute() error {
+	ctx := context.Background()
+	creds, err := w.Config.credentials(ctx)
+	if err != nil {
+		return err
+	}
+	token, err := creds.tokenSource.Token()
+	if err != nil {
+		return NewServiceError("Failed to get an access token", err)
+	}
+	principal := creds.principal
+	if principal == "" {
+		if principal, err = tokenEmail(ctx, token); err != nil {
+			log.WithError(err).Warning("Failed to detect the account of the credentials")
+		}
+	}
+	if principal == "" {
+		principal = "(unknown)"
+	}
+	if err := w.Config.resolveProject(); err != nil {
+		log.WithError(err).Warning("Failed to resolve the project")
+	}
+	project := w.Config.Project
+	if project == "" {
+		project = "(none)"
+	}
+	fmt.Fprintf(os.Stdout, "Principal:   %v\n", principal)
+	fmt.Fprintf(os.Stdout, "Credentials: %v\n", creds.source)
+	fmt.Fprintf(os.Stdout, "Project:     %v\n", project)
+	return nil
+}
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func writeTestAccessToken(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cloudbuild-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	file := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(file, []byte("test-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestCredentialsAreResolvedOnce(t *testing.T) {
+	config := &Config{AccessTokenFile: writeTestAccessToken(t)}
+	config.shareCredentials()
+	copied := *config
+
+	creds, err := config.credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := config.credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds != again {
+		t.Error("credentials are resolved again")
+	}
+	fromCopy, err := copied.credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds != fromCopy {
+		t.Error("credentials are not shared with the copy of the config")
+	}
+}
+
+func TestCredentialsRejectAccessTokenWithCredentialsFile(t *testing.T) {
+	config := &Config{
+		AccessTokenFile: writeTestAccessToken(t),
+		CredentialsFile: "credentials.json",
+	}
+	_, err := config.credentials(context.Background())
+	var configError *ConfigError
+	if !xerrors.As(err, &configError) {
+		t.Errorf("expected ConfigError, got %v", err)
+	}
+}
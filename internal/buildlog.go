@@ -0,0 +1,384 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+
+	"github.com/ikedam/cloudbuild/log"
+)
+
+// buildLogOutput is the destination of build logs.
+type buildLogOutput struct {
+	writer   io.Writer
+	closers  []io.Closer
+	handlers []buildLogLineHandler
+}
+
+// addLineHandler registers a handler to process build logs line by line.
+func (o *buildLogOutput) addLineHandler(handler buildLogLineHandler) {
+	o.handlers = append(o.handlers, handler)
+}
+
+func (o *buildLogOutput) Write(p []byte) (int, error) {
+	return o.writer.Write(p)
+}
+
+// Close closes all files opened for outputs.
+func (o *buildLogOutput) Close() error {
+	var firstErr error
+	for _, closer := range o.closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// openBuildLogWriter opens the destination to write build logs to
+// according to the configuration.
+func (s *CloudBuildSubmit) openBuildLogWriter() (*buildLogOutput, error) {
+	output := &buildLogOutput{}
+	writers := []io.Writer{}
+	if !s.Config.QuietBuildLog {
+		if s.logPrefix != "" {
+			// Logs of other builds are printed in parallel.
+			// Sections cannot be used as they would be mixed.
+			output.addLineHandler(&buildLogRenderer{
+				out:        s.consoleWriter(),
+				prefix:     s.logPrefix,
+				colorize:   s.Config.ColorBuildLog,
+				timestamps: s.Config.BuildLogTimestamps,
+				steps:      s.Config.Steps,
+			})
+		} else if s.Config.useBuildLogRenderer() || s.ci != nil {
+			output.addLineHandler(&buildLogRenderer{
+				out:        s.consoleWriter(),
+				colorize:   s.Config.ColorBuildLog,
+				timestamps: s.Config.BuildLogTimestamps,
+				steps:      s.Config.Steps,
+				ci:         s.ci,
+			})
+		} else {
+			writers = append(writers, s.consoleWriter())
+		}
+	}
+	if s.Config.FailedStepLogLines > 0 {
+		s.stepLogTail = newStepLogTail(s.Config.FailedStepLogLines)
+		output.addLineHandler(s.stepLogTail)
+	}
+	if s.Config.JUnitReport != "" {
+		s.stepLogCollector = newStepLogCollector()
+		output.addLineHandler(s.stepLogCollector)
+	}
+	if s.Config.LogFile != "" {
+		log.WithField("file", s.Config.LogFile).Debug("Saving build log to the file")
+		fd, err := os.Create(s.Config.LogFile)
+		if err != nil {
+			return nil, NewConfigError(
+				"Failed to open the build log file",
+				xerrors.Errorf("Failed to create %v: %w", s.Config.LogFile, err),
+			)
+		}
+		writers = append(writers, fd)
+		output.closers = append(output.closers, fd)
+	}
+	if len(output.handlers) > 0 {
+		splitter := &buildLogSplitter{
+			handlers: output.handlers,
+		}
+		writers = append(writers, splitter)
+		// flush the last line before closing files.
+		output.closers = append([]io.Closer{splitter}, output.closers...)
+	}
+	output.writer = io.MultiWriter(writers...)
+	// Secrets are registered also for substitutions sensitive with key patterns.
+	if log.HasSecrets() {
+		masking := &maskingWriter{out: output.writer}
+		output.writer = masking
+		// flush the last line before closing other outputs.
+		output.closers = append([]io.Closer{masking}, output.closers...)
+	}
+	return output, nil
+}
+
+// consoleWriter returns the destination to print build logs.
+func (s *CloudBuildSubmit) consoleWriter() io.Writer {
+	if s.console == nil {
+		return os.Stdout
+	}
+	return s.console
+}
+
+// stepLogPrefix matches prefixes Cloud Build adds to logs of steps:
+// `Step #0 - "id": ` for steps with ids and `Step #0: ` for steps without ids.
+var stepLogPrefix = regexp.MustCompile(`^Step #(\d+)(?: - "([^"]*)")?: ?`)
+
+// buildLogLine is a line of build logs.
+type buildLogLine struct {
+	// Step is the index of the step. -1 for lines not belonging to any steps.
+	Step int
+
+	// StepID is the id of the step. Empty if the step has no id.
+	StepID string
+
+	// Prefix is the prefix Cloud Build adds to the line.
+	Prefix string
+
+	// Text is the content of the line without the prefix and the line break.
+	Text string
+}
+
+// parseBuildLogLine parses a line of build logs.
+func parseBuildLogLine(line string) *buildLogLine {
+	match := stepLogPrefix.FindStringSubmatch(line)
+	if match == nil {
+		return &buildLogLine{
+			Step: -1,
+			Text: line,
+		}
+	}
+	step, err := strconv.Atoi(match[1])
+	if err != nil {
+		return &buildLogLine{
+			Step: -1,
+			Text: line,
+		}
+	}
+	return &buildLogLine{
+		Step:   step,
+		StepID: match[2],
+		Prefix: match[0],
+		Text:   line[len(match[0]):],
+	}
+}
+
+// IsStep returns whether the line is the output of the step.
+// step is the id or the index of the step.
+func (l *buildLogLine) IsStep(step string) bool {
+	if l.Step < 0 {
+		return false
+	}
+	return (l.StepID != "" && l.StepID == step) || strconv.Itoa(l.Step) == step
+}
+
+// buildLogLineHandler processes build logs line by line.
+type buildLogLineHandler interface {
+	handleLine(line *buildLogLine) error
+}
+
+// buildLogSplitter splits build logs into lines.
+// Lines can be split across multiple writes as logs are read from Cloud Storage
+// every polling.
+type buildLogSplitter struct {
+	handlers []buildLogLineHandler
+	partial  []byte
+}
+
+func (s *buildLogSplitter) Write(p []byte) (int, error) {
+	s.partial = append(s.partial, p...)
+	for {
+		idx := bytes.IndexByte(s.partial, '\n')
+		if idx < 0 {
+			break
+		}
+		line := strings.TrimSuffix(string(s.partial[:idx]), "\r")
+		s.partial = s.partial[idx+1:]
+		if err := s.handleLine(line); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Close flushes the last line not terminated with a line break,
+// and closes handlers.
+func (s *buildLogSplitter) Close() error {
+	if len(s.partial) > 0 {
+		line := string(s.partial)
+		s.partial = nil
+		if err := s.handleLine(line); err != nil {
+			return err
+		}
+	}
+	for _, handler := range s.handlers {
+		if closer, ok := handler.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *buildLogSplitter) handleLine(line string) error {
+	parsed := parseBuildLogLine(line)
+	for _, handler := range s.handlers {
+		if err := handler.handleLine(parsed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stepColors is the ANSI colors to decorate step prefixes.
+var stepColors = []string{
+	"\x1b[36m", // cyan
+	"\x1b[33m", // yellow
+	"\x1b[32m", // green
+	"\x1b[35m", // magenta
+	"\x1b[34m", // blue
+	"\x1b[96m", // bright cyan
+	"\x1b[93m", // bright yellow
+	"\x1b[92m", // bright green
+	"\x1b[95m", // bright magenta
+	"\x1b[94m", // bright blue
+}
+
+const colorReset = "\x1b[0m"
+
+// buildLogRenderer prints build logs in human friendly format.
+type buildLogRenderer struct {
+	out        io.Writer
+	prefix     string
+	colorize   bool
+	timestamps bool
+	steps      []string
+
+	// ci groups logs of each step into sections if set.
+	ci          ciIntegration
+	inSection   bool
+	sectionStep int
+	sectionName string
+	sectionSeq  int
+}
+
+func (r *buildLogRenderer) handleLine(line *buildLogLine) error {
+	if !r.isSelected(line) {
+		return nil
+	}
+	if r.ci != nil {
+		if err := r.switchSection(line); err != nil {
+			return xerrors.Errorf("Failed to write build log: %w", err)
+		}
+	}
+	var b strings.Builder
+	b.WriteString(r.prefix)
+	if r.timestamps {
+		b.WriteString(time.Now().Format("15:04:05.000 "))
+	}
+	if r.colorize && line.Step >= 0 {
+		b.WriteString(stepColors[line.Step%len(stepColors)])
+		b.WriteString(line.Prefix)
+		b.WriteString(colorReset)
+	} else {
+		b.WriteString(line.Prefix)
+	}
+	b.WriteString(line.Text)
+	b.WriteString("\n")
+	if _, err := io.WriteString(r.out, b.String()); err != nil {
+		return xerrors.Errorf("Failed to write build log: %w", err)
+	}
+	return nil
+}
+
+// switchSection starts a new section when the step outputting logs changes.
+// Logs of parallel steps result in multiple sections for the same step.
+func (r *buildLogRenderer) switchSection(line *buildLogLine) error {
+	if r.inSection && line.Step == r.sectionStep {
+		return nil
+	}
+	if r.inSection {
+		r.inSection = false
+		if err := r.ci.endSection(r.out, r.sectionName); err != nil {
+			return err
+		}
+	}
+	if line.Step < 0 {
+		return nil
+	}
+	r.sectionSeq++
+	r.sectionName = fmt.Sprintf("step_%v_%v", line.Step, r.sectionSeq)
+	r.sectionStep = line.Step
+	r.inSection = true
+	title := strings.TrimSuffix(strings.TrimSpace(line.Prefix), ":")
+	return r.ci.startSection(r.out, r.sectionName, title)
+}
+
+// Close ends the last section.
+func (r *buildLogRenderer) Close() error {
+	if r.ci == nil || !r.inSection {
+		return nil
+	}
+	r.inSection = false
+	return r.ci.endSection(r.out, r.sectionName)
+}
+
+// isSelected returns whether the line should be printed.
+// Lines not belonging to steps are always printed.
+func (r *buildLogRenderer) isSelected(line *buildLogLine) bool {
+	if len(r.steps) == 0 || line.Step < 0 {
+		return true
+	}
+	for _, step := range r.steps {
+		if line.IsStep(step) {
+			return true
+		}
+	}
+	return false
+}
+
+// stepLogTail keeps the last lines of logs for each step.
+type stepLogTail struct {
+	size  int
+	lines map[int][]string
+}
+
+func newStepLogTail(size int) *stepLogTail {
+	return &stepLogTail{
+		size:  size,
+		lines: make(map[int][]string),
+	}
+}
+
+func (t *stepLogTail) handleLine(line *buildLogLine) error {
+	if line.Step < 0 {
+		return nil
+	}
+	lines := append(t.lines[line.Step], line.Prefix+line.Text)
+	if len(lines) > t.size {
+		lines = lines[len(lines)-t.size:]
+	}
+	t.lines[line.Step] = lines
+	return nil
+}
+
+// Lines returns the last lines of the step.
+func (t *stepLogTail) Lines(step int) []string {
+	return t.lines[step]
+}
+
+// printFailedStepLogs prints the last lines of logs of failed steps again
+// so that users can find the cause without scrolling back.
+func (s *CloudBuildSubmit) printFailedStepLogs(build *cloudbuild.Build) {
+	if s.stepLogTail == nil {
+		return
+	}
+	var b strings.Builder
+	for _, step := range failedSteps(build) {
+		lines := s.stepLogTail.Lines(step.Index)
+		fmt.Fprintf(&b, "%v----- Last %v lines of %v -----\n", s.logPrefix, len(lines), step)
+		for _, line := range lines {
+			fmt.Fprintf(&b, "%v%v\n", s.logPrefix, line)
+		}
+	}
+	io.WriteString(s.consoleWriter(), b.String())
+}
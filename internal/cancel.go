@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+
+	"github.com/ikedam/cloudbuild/log"
+)
+
+// CloudBuildCancel holds running state of cancelling builds
+type CloudBuildCancel struct {
+	Config
+
+	// BuildIDs is the ids of builds to cancel.
+	BuildIDs []string
+
+	// AllOngoing cancels all queued or working builds matching Tags and Filter.
+	AllOngoing bool
+
+	// Tags is the tags of builds to cancel with AllOngoing.
+	Tags []string
+
+	// Filter is the filter expression of Cloud Build to select builds to cancel with AllOngoing.
+	Filter string
+
+	// Yes allows AllOngoing without Tags and Filter to cancel all ongoing builds in the project.
+	Yes bool
+
+	// DryRun only prints ids of builds to cancel.
+	DryRun bool
+}
+
+// Execute cancels builds
+func (c *CloudBuildCancel) Execute() error {
+	if len(c.BuildIDs) == 0 && !c.AllOngoing {
+		return NewConfigError("Specify builds to cancel or --all-ongoing", nil)
+	}
+	if c.AllOngoing && len(c.Tags) == 0 && c.Filter == "" && !c.Yes {
+		return NewConfigError(
+			"--all-ongoing without --tag or --filter cancels all ongoing builds in the project. Specify --yes to do that",
+			nil,
+		)
+	}
+	if err := c.Config.resolveProject(); err != nil {
+		return err
+	}
+
+	buildIDs := append([]string{}, c.BuildIDs...)
+	if c.AllOngoing {
+		options := &BuildListOptions{
+			Statuses: []string{"QUEUED", "WORKING"},
+			Tags:     c.Tags,
+			Filter:   c.Filter,
+		}
+		filter, err := options.filterExpression(time.Now())
+		if err != nil {
+			return NewConfigError("Invalid conditions to cancel builds", err)
+		}
+		builds, err := c.Config.listBuilds(filter, 0)
+		if err != nil {
+			return err
+		}
+		for _, build := range builds {
+			buildIDs = append(buildIDs, build.Id)
+		}
+		if len(builds) == 0 {
+			log.WithField("filter", filter).Info("No ongoing builds to cancel")
+		}
+	}
+
+	if c.DryRun {
+		for _, buildID := range buildIDs {
+			fmt.Println(buildID)
+		}
+		return nil
+	}
+
+	ctx := context.Background()
+	service, err := c.Config.newCloudBuildService(ctx)
+	if err != nil {
+		return err
+	}
+	buildService := cloudbuild.NewProjectsBuildsService(service)
+
+	failed := []string{}
+	var lastErr error
+	for _, buildID := range buildIDs {
+		log.WithField("buildID", buildID).Info("Canceling build...")
+		if err := c.Config.cancelBuild(ctx, buildService, buildID); err != nil {
+			log.WithError(err).WithField("buildID", buildID).Error("Failed to cancel build")
+			failed = append(failed, buildID)
+			lastErr = err
+			continue
+		}
+		log.WithField("buildID", buildID).Info("Canceled")
+	}
+	if len(failed) > 0 {
+		return NewServiceError(
+			fmt.Sprintf("Failed to cancel builds %v", strings.Join(failed, ", ")),
+			lastErr,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/ikedam/cloudbuild/log"
+)
+
+// fileFilters is files to decide whether the build is necessary,
+// declared with includedFiles and ignoredFiles in cloudbuild.yaml
+// like build triggers of Cloud Build.
+type fileFilters struct {
+	// IncludedFiles is globs of files to require builds.
+	// Changes of any files require builds if empty.
+	IncludedFiles []string
+	// IgnoredFiles is globs of files not to require builds.
+	IgnoredFiles []string
+}
+
+// extractFileFilters removes includedFiles and ignoredFiles from cloudbuild.yaml
+// as they're not fields of builds.
+func extractFileFilters(m map[string]interface{}) (*fileFilters, error) {
+	filters := &fileFilters{}
+	var err error
+	if filters.IncludedFiles, err = extractStringList(m, "includedFiles"); err != nil {
+		return nil, err
+	}
+	if filters.IgnoredFiles, err = extractStringList(m, "ignoredFiles"); err != nil {
+		return nil, err
+	}
+	return filters, nil
+}
+
+func extractStringList(m map[string]interface{}, key string) ([]string, error) {
+	value, ok := m[key]
+	if !ok {
+		return nil, nil
+	}
+	delete(m, key)
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, xerrors.Errorf("%v must be a list of globs", key)
+	}
+	list := make([]string, 0, len(values))
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return nil, xerrors.Errorf("%v must be a list of globs: %v", key, v)
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// skipReason returns the reason to skip the build for changed files.
+// Returns an empty string if the build is necessary.
+func (f *fileFilters) skipReason(changedFiles []string) string {
+	if len(changedFiles) == 0 {
+		return "No files changed"
+	}
+	relevant := []string{}
+	for _, file := range changedFiles {
+		if f != nil && matchesAnyGlob(f.IgnoredFiles, file) {
+			continue
+		}
+		relevant = append(relevant, file)
+	}
+	if len(relevant) == 0 {
+		return "All changed files match ignoredFiles"
+	}
+	if f == nil || len(f.IncludedFiles) == 0 {
+		return ""
+	}
+	for _, file := range relevant {
+		if matchesAnyGlob(f.IncludedFiles, file) {
+			return ""
+		}
+	}
+	return "No changed files match includedFiles"
+}
+
+// checkChanges returns SkippedError if no relevant files changed since ChangedSince.
+func (s *CloudBuildSubmit) checkChanges() error {
+	changedFiles, err := s.Config.changedFiles()
+	if err != nil {
+		return err
+	}
+	return s.skipForChanges(changedFiles)
+}
+
+// skipForChanges returns SkippedError if no relevant files are in changedFiles.
+func (s *CloudBuildSubmit) skipForChanges(changedFiles []string) error {
+	if reason := s.fileFilters.skipReason(changedFiles); reason != "" {
+		return NewSkippedError(fmt.Sprintf("%v since %v", reason, s.Config.ChangedSince))
+	}
+	return nil
+}
+
+// changedFiles lists files changed in the source directory since ChangedSince
+// including files not committed yet.
+// Paths are relative to the source directory.
+func (c *Config) changedFiles() ([]string, error) {
+	diff, err := runGit(c.SourceDir, "diff", "--name-only", "--relative", c.ChangedSince, "--")
+	if err != nil {
+		return nil, NewConfigError(
+			fmt.Sprintf("Failed to detect changes since %v", c.ChangedSince),
+			err,
+		)
+	}
+	untracked, err := runGit(c.SourceDir, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, NewConfigError("Failed to detect untracked files", err)
+	}
+	files := append(splitLines(diff), splitLines(untracked)...)
+	log.WithField("since", c.ChangedSince).WithField("files", files).Debug("Detected changed files")
+	return files, nil
+}
+
+// runGit runs git command in the directory and returns its output.
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if xerrors.Is(err, exec.ErrNotFound) {
+		return "", xerrors.Errorf("git command is required to detect changes: %w", err)
+	}
+	if err != nil {
+		return "", xerrors.Errorf("git %v: %v: %w", strings.Join(args, " "), strings.TrimSpace(stderr.String()), err)
+	}
+	return string(out), nil
+}
+
+// matchesAnyGlob tests the file matches any of globs.
+func matchesAnyGlob(globs []string, file string) bool {
+	for _, glob := range globs {
+		if matchGlob(glob, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob tests the slash separated path matches the glob.
+// `**` matches any number of directories in addition to path.Match patterns.
+func matchGlob(glob string, file string) bool {
+	return matchGlobSegments(strings.Split(glob, "/"), strings.Split(file, "/"))
+}
+
+func matchGlobSegments(globs []string, segments []string) bool {
+	if len(globs) == 0 {
+		return len(segments) == 0
+	}
+	if globs[0] == "**" {
+		for idx := 0; idx <= len(segments); idx++ {
+			if matchGlobSegments(globs[1:], segments[idx:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if matched, err := path.Match(globs[0], segments[0]); err != nil || !matched {
+		return false
+	}
+	return matchGlobSegments(globs[1:], segments[1:])
+}
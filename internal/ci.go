@@ -0,0 +1,285 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+	"golang.org/x/xerrors"
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+
+	"github.com/ikedam/cloudbuild/log"
+)
+
+const (
+	// CIIntegrationNone disables integrations with CI services.
+	CIIntegrationNone = ""
+	// CIIntegrationAuto detects the CI service from environment variables.
+	CIIntegrationAuto = "auto"
+	// CIIntegrationGitHub integrates with GitHub Actions.
+	CIIntegrationGitHub = "github"
+	// CIIntegrationGitLab integrates with GitLab CI/CD.
+	CIIntegrationGitLab = "gitlab"
+)
+
+// ciIntegration provides features specific to CI services.
+type ciIntegration interface {
+	// startSection starts a collapsible section of the build log.
+	startSection(w io.Writer, name, title string) error
+
+	// endSection ends the collapsible section started with startSection.
+	endSection(w io.Writer, name string) error
+
+	// reportResult reports the result of the build to the CI service.
+	// name is the name of the build when reporting results of multiple builds, and empty otherwise.
+	// Outputs are prefixed with the name not to overwrite outputs of other builds.
+	reportResult(name string, build *cloudbuild.Build) error
+}
+
+// newCIIntegration returns the integration for the configured CI service.
+// Returns nil if no integration is configured.
+func newCIIntegration(config *Config) (ciIntegration, error) {
+	ci := config.CIIntegration
+	if ci == CIIntegrationAuto {
+		ci = detectCIService()
+		log.WithField("ci", ci).Debug("Detected CI service")
+	}
+	switch ci {
+	case CIIntegrationNone:
+		return nil, nil
+	case CIIntegrationGitHub:
+		return &githubActions{
+			outputFile:  os.Getenv("GITHUB_OUTPUT"),
+			summaryFile: os.Getenv("GITHUB_STEP_SUMMARY"),
+		}, nil
+	case CIIntegrationGitLab:
+		return &gitlabCI{
+			dotenvFile: config.CIDotenvFile,
+		}, nil
+	}
+	return nil, NewConfigError(
+		fmt.Sprintf("Unknown CI integration '%v'", config.CIIntegration),
+		nil,
+	)
+}
+
+// detectCIService detects the CI service running cloudbuild
+// with environment variables the CI service sets.
+func detectCIService() string {
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		return CIIntegrationGitHub
+	}
+	if os.Getenv("GITLAB_CI") == "true" {
+		return CIIntegrationGitLab
+	}
+	return CIIntegrationNone
+}
+
+// builtImages returns images pushed by the build in name@digest format.
+func builtImages(build *cloudbuild.Build) []string {
+	images := []string{}
+	if build.Results == nil {
+		return images
+	}
+	for _, image := range build.Results.Images {
+		if image.Digest != "" {
+			images = append(images, fmt.Sprintf("%v@%v", image.Name, image.Digest))
+		} else {
+			images = append(images, image.Name)
+		}
+	}
+	return images
+}
+
+// appendToFile appends the contents to the file.
+func appendToFile(file, contents string) error {
+	fd, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return xerrors.Errorf("Failed to open %v: %w", file, err)
+	}
+	defer fd.Close()
+	if _, err := io.WriteString(fd, contents); err != nil {
+		return xerrors.Errorf("Failed to write to %v: %w", file, err)
+	}
+	return nil
+}
+
+// githubActions is the integration for GitHub Actions.
+// See https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions
+type githubActions struct {
+	outputFile  string
+	summaryFile string
+}
+
+func (g *githubActions) startSection(w io.Writer, name, title string) error {
+	_, err := fmt.Fprintf(w, "::group::%v\n", title)
+	return err
+}
+
+func (g *githubActions) endSection(w io.Writer, name string) error {
+	_, err := fmt.Fprintln(w, "::endgroup::")
+	return err
+}
+
+func (g *githubActions) reportResult(name string, build *cloudbuild.Build) error {
+	title := escapeGitHubProperty(fmt.Sprintf("Cloud Build %v failed", build.Id))
+	steps := failedSteps(build)
+	for _, step := range steps {
+		fmt.Fprintf(os.Stdout, "::error title=%v::%v\n", title, escapeGitHubData(step.String()))
+	}
+	if len(steps) == 0 && build.Status != "SUCCESS" {
+		// Builds can fail without failed steps
+		// like timeouts, cancellations and internal errors.
+		message := fmt.Sprintf("Build %v finished with %v", build.Id, build.Status)
+		if build.FailureInfo != nil && build.FailureInfo.Detail != "" {
+			message = fmt.Sprintf("%v: %v", message, build.FailureInfo.Detail)
+		}
+		fmt.Fprintf(os.Stdout, "::error title=%v::%v\n", title, escapeGitHubData(message))
+	}
+	if g.outputFile != "" {
+		if err := appendToFile(g.outputFile, g.outputs(name, build)); err != nil {
+			return err
+		}
+	} else {
+		log.Debug("Skip writing outputs as GITHUB_OUTPUT is not set")
+	}
+	if g.summaryFile != "" {
+		if err := appendToFile(g.summaryFile, buildSummaryMarkdown(name, build)); err != nil {
+			return err
+		}
+	} else {
+		log.Debug("Skip writing the job summary as GITHUB_STEP_SUMMARY is not set")
+	}
+	return nil
+}
+
+// outputs returns the outputs of the step in the format of GITHUB_OUTPUT.
+// Names of outputs are prefixed with the name of the build like api-build-id if name is not empty.
+func (g *githubActions) outputs(name string, build *cloudbuild.Build) string {
+	prefix := ""
+	if name != "" {
+		prefix = sanitizeBuildName(name) + "-"
+	}
+	delimiter := fmt.Sprintf("ghadelimiter_%v", xid.New().String())
+	var b strings.Builder
+	fmt.Fprintf(&b, "%vbuild-id=%v\n", prefix, build.Id)
+	fmt.Fprintf(&b, "%vstatus=%v\n", prefix, build.Status)
+	fmt.Fprintf(&b, "%vlog-url=%v\n", prefix, build.LogUrl)
+	fmt.Fprintf(&b, "%vimages<<%v\n", prefix, delimiter)
+	for _, image := range builtImages(build) {
+		fmt.Fprintln(&b, image)
+	}
+	fmt.Fprintln(&b, delimiter)
+	return b.String()
+}
+
+func escapeGitHubData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func escapeGitHubProperty(s string) string {
+	return strings.NewReplacer(
+		"%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C",
+	).Replace(s)
+}
+
+// buildSummaryMarkdown returns the summary of the build in Markdown format.
+// The heading has the name of the build if name is not empty.
+func buildSummaryMarkdown(name string, build *cloudbuild.Build) string {
+	var b strings.Builder
+	if name != "" {
+		fmt.Fprintf(&b, "### %v: Cloud Build %v\n\n", name, build.Status)
+	} else {
+		fmt.Fprintf(&b, "### Cloud Build %v\n\n", build.Status)
+	}
+	fmt.Fprintln(&b, "| | |")
+	fmt.Fprintln(&b, "|---|---|")
+	fmt.Fprintf(&b, "| Build ID | `%v` |\n", build.Id)
+	fmt.Fprintf(&b, "| Status | %v |\n", build.Status)
+	if duration, ok := timeSpanDuration(build.StartTime, build.FinishTime); ok {
+		fmt.Fprintf(&b, "| Duration | %v |\n", duration)
+	}
+	if build.LogUrl != "" {
+		fmt.Fprintf(&b, "| Log | [Open in Cloud Console](%v) |\n", build.LogUrl)
+	}
+	if len(build.Steps) > 0 {
+		fmt.Fprint(&b, "\n#### Steps\n\n")
+		fmt.Fprintln(&b, "| # | ID | Name | Status | Duration |")
+		fmt.Fprintln(&b, "|---|---|---|---|---|")
+		for idx, step := range build.Steps {
+			duration := ""
+			if step.Timing != nil {
+				if d, ok := timeSpanDuration(step.Timing.StartTime, step.Timing.EndTime); ok {
+					duration = d.String()
+				}
+			}
+			fmt.Fprintf(&b, "| %v | %v | `%v` | %v | %v |\n", idx, step.Id, step.Name, step.Status, duration)
+		}
+	}
+	if images := builtImages(build); len(images) > 0 {
+		fmt.Fprint(&b, "\n#### Images\n\n")
+		for _, image := range images {
+			fmt.Fprintf(&b, "* `%v`\n", image)
+		}
+	}
+	fmt.Fprintln(&b)
+	return b.String()
+}
+
+// gitlabCI is the integration for GitLab CI/CD.
+// See https://docs.gitlab.com/ee/ci/jobs/#custom-collapsible-sections
+type gitlabCI struct {
+	dotenvFile string
+	// written is set once the dotenv file is written in this run.
+	written bool
+}
+
+func (g *gitlabCI) startSection(w io.Writer, name, title string) error {
+	_, err := fmt.Fprintf(
+		w,
+		"\x1b[0Ksection_start:%v:%v[collapsed=true]\r\x1b[0K%v\n",
+		time.Now().Unix(),
+		name,
+		title,
+	)
+	return err
+}
+
+func (g *gitlabCI) endSection(w io.Writer, name string) error {
+	_, err := fmt.Fprintf(w, "\x1b[0Ksection_end:%v:%v\r\x1b[0K\n", time.Now().Unix(), name)
+	return err
+}
+
+// reportResult writes variables to the dotenv file.
+// Variables are prefixed with the name of the build like CLOUDBUILD_API_BUILD_ID if name is not empty.
+func (g *gitlabCI) reportResult(name string, build *cloudbuild.Build) error {
+	if g.dotenvFile == "" {
+		return nil
+	}
+	prefix := "CLOUDBUILD_"
+	if name != "" {
+		prefix = fmt.Sprintf(
+			"%v%v_",
+			prefix,
+			strings.ToUpper(strings.ReplaceAll(sanitizeBuildName(name), "-", "_")),
+		)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%vBUILD_ID=%v\n", prefix, build.Id)
+	fmt.Fprintf(&b, "%vSTATUS=%v\n", prefix, build.Status)
+	fmt.Fprintf(&b, "%vLOG_URL=%v\n", prefix, build.LogUrl)
+	fmt.Fprintf(&b, "%vIMAGES=%v\n", prefix, strings.Join(builtImages(build), ","))
+	if g.written {
+		return appendToFile(g.dotenvFile, b.String())
+	}
+	// Overwrite the file written in previous runs in the same workspace.
+	if err := ioutil.WriteFile(g.dotenvFile, []byte(b.String()), 0644); err != nil {
+		return xerrors.Errorf("Failed to write to %v: %w", g.dotenvFile, err)
+	}
+	g.written = true
+	return nil
+}
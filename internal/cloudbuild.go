@@ -29,13 +29,88 @@ import (
 // CloudBuildSubmit holds running state of build submission
 type CloudBuildSubmit struct {
 	Config
-	sourcePath     *GcsPath
-	buildID        string
-	completeStatus string
+	sourcePath       *GcsPath
+	buildID          string
+	completeStatus   string
+	result           *cloudbuild.Build
+	stepLogTail      *stepLogTail
+	stepLogCollector *stepLogCollector
+	ci               ciIntegration
+	fileFilters      *fileFilters
+
+	// tracer records spans of the submission. nil if tracing is disabled.
+	tracer *tracer
+	// span is the span of the submission.
+	span *span
+
+	// name is the name of the build in metrics. Config is used if empty.
+	name string
+
+	// console is the destination to print build logs. os.Stdout if nil.
+	console io.Writer
+	// logPrefix is prefixed to each line of build logs printed to the console.
+	logPrefix string
 }
 
 // Execute performs the sequence to submit a build to CloudBuild
 func (s *CloudBuildSubmit) Execute() error {
+	var err error
+	if s.tracer, err = s.Config.newTracer(); err != nil {
+		return err
+	}
+	s.span = s.tracer.startSpan("submit", nil).setAttribute("config", s.Config.Config)
+	err = s.execute()
+	s.span.finish(err)
+	s.tracer.flush()
+	s.Config.exportMetrics()
+	return err
+}
+
+func (s *CloudBuildSubmit) execute() error {
+	var err error
+	if s.ci, err = newCIIntegration(&s.Config); err != nil {
+		return err
+	}
+
+	if err := s.prepareSourcePath(); err != nil {
+		return err
+	}
+
+	build, err := s.readCloudBuild()
+	if err != nil {
+		return NewConfigError(
+			fmt.Sprintf("Failed to read %v", s.Config.Config),
+			err,
+		)
+	}
+
+	if s.Config.ChangedSince != "" {
+		if err := s.checkChanges(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.uploadSource(); err != nil {
+		return err
+	}
+
+	if err := s.startBuild(build); err != nil {
+		return err
+	}
+
+	return s.watchAndReport()
+}
+
+// buildName returns the name of the build in metrics.
+func (s *CloudBuildSubmit) buildName() string {
+	if s.name != "" {
+		return s.name
+	}
+	return s.Config.Config
+}
+
+// prepareSourcePath decides the location to upload the source archive.
+func (s *CloudBuildSubmit) prepareSourcePath() error {
 	sourcePath := fmt.Sprintf(
 		"%v/%v.tgz",
 		s.Config.GcsSourceStagingDir,
@@ -49,18 +124,16 @@ func (s *CloudBuildSubmit) Execute() error {
 			err,
 		)
 	}
+	return nil
+}
 
-	build, err := s.readCloudBuild()
-	if err != nil {
-		return NewConfigError(
-			fmt.Sprintf("Failed to read %v", s.Config.Config),
-			err,
-		)
-	}
-
+// uploadSource archives the source directory and uploads it retrying for errors.
+func (s *CloudBuildSubmit) uploadSource() error {
 	for backoff := NewBackoff(); true; {
 		if err := func() error {
+			archiveSpan := s.span.startChild("createSourceArchive").setAttribute("attempt", backoff.Attempt())
 			tar, err := s.createSourceArchive()
+			archiveSpan.finish(err)
 			if err != nil {
 				return NewConfigError(
 					fmt.Sprintf("Failed to create source arvhive %v", s.Config.SourceDir),
@@ -69,7 +142,10 @@ func (s *CloudBuildSubmit) Execute() error {
 			}
 			defer tar.Close()
 
-			if err := s.uploadCloudStorage(tar); err != nil {
+			uploadSpan := s.span.startChild("uploadCloudStorage").setAttribute("attempt", backoff.Attempt())
+			err = s.uploadCloudStorage(tar)
+			uploadSpan.finish(err)
+			if err != nil {
 				return NewServiceError(
 					fmt.Sprintf("Failed to upload source arvhive to %v", s.sourcePath),
 					err,
@@ -79,7 +155,11 @@ func (s *CloudBuildSubmit) Execute() error {
 		}(); err != nil {
 			if (s.Config.MaxUploadTryCount <= 0 || backoff.Attempt() < s.Config.MaxUploadTryCount) &&
 				isRetryableError(err) {
-				log.WithError(err).WithField("attempt", backoff.Attempt()).
+				recordRetry("upload", err)
+				log.WithError(err).
+					WithField("gcsBucket", s.sourcePath.Bucket).
+					WithField("gcsObject", s.sourcePath.Object).
+					WithField("attempt", backoff.Attempt()).
 					Warning("Failed to upload. Retrying...")
 				backoff.Sleep()
 				continue
@@ -88,12 +168,23 @@ func (s *CloudBuildSubmit) Execute() error {
 		}
 		break
 	}
+	return nil
+}
 
+// startBuild starts the build for the uploaded source retrying for errors.
+func (s *CloudBuildSubmit) startBuild(build *cloudbuild.Build) error {
+	setTraceparent(build, s.span)
 	for backoff := NewBackoff(); true; {
-		err = s.runCloudBuild(build)
+		runSpan := s.span.startChild("runCloudBuild").setAttribute("attempt", backoff.Attempt())
+		err := s.runCloudBuild(build)
+		if err == nil {
+			runSpan.setAttribute("buildID", s.buildID)
+		}
+		runSpan.finish(err)
 		if err != nil {
 			if (s.Config.MaxStartBuildTryCount <= 0 || backoff.Attempt() < s.Config.MaxStartBuildTryCount) &&
 				isRetryableError(err) {
+				recordRetry("start build", err)
 				log.WithError(err).WithField("attempt", backoff.Attempt()).
 					Warning("Failed to start build. Retrying...")
 				backoff.Sleep()
@@ -106,13 +197,36 @@ func (s *CloudBuildSubmit) Execute() error {
 		}
 		break
 	}
+	return nil
+}
 
-	status, err := s.watchCloudBuild(s.buildID)
+// watchAndReport watches the started build until it completes,
+// and reports the result.
+func (s *CloudBuildSubmit) watchAndReport() error {
+	watchSpan := s.span.startChild("watchCloudBuild").setAttribute("buildID", s.buildID)
+	result, err := s.watchCloudBuild(s.buildID, watchSpan)
+	watchSpan.finish(err)
 	if err != nil {
 		return err
 	}
-	if status != "SUCCESS" {
-		return NewBuildResultError(s.buildID, status)
+	s.result = result
+	s.span.setAttribute("buildID", result.Id).setAttribute("status", result.Status)
+	recordBuildSpans(s.span, result)
+	recordBuildMetrics(s.buildName(), result.Status, result.CreateTime, result.StartTime, result.FinishTime)
+	if s.Config.JUnitReport != "" {
+		if err := s.writeJUnitReport(result); err != nil {
+			log.WithError(err).Warning("Failed to write the JUnit report")
+		}
+	}
+	if s.ci != nil {
+		if err := s.ci.reportResult("", result); err != nil {
+			log.WithError(err).Warning("Failed to report the result to the CI service")
+		}
+	}
+	s.Config.notify(result)
+	if result.Status != "SUCCESS" {
+		s.printFailedStepLogs(result)
+		return NewBuildResultErrorForBuild(result)
 	}
 
 	return nil
@@ -140,30 +254,56 @@ func (s *CloudBuildSubmit) readCloudBuild() (*cloudbuild.Build, error) {
 	if err := yaml.Unmarshal(yamlBody, &m); err != nil {
 		return nil, xerrors.Errorf("Failed to read %v: %w", s.Config.Config, err)
 	}
+	if s.fileFilters, err = extractFileFilters(m); err != nil {
+		return nil, xerrors.Errorf("Failed to read %v: %w", s.Config.Config, err)
+	}
 	jsonData, err := json.MarshalIndent(&m, "", "  ")
 	if err != nil {
 		return nil, xerrors.Errorf("Failed to serialize %v: %w", s.Config.Config, err)
 	}
-	log.WithField("json", jsonData).Trace("Marshal cloudbuild.yaml to json format")
 	build := &cloudbuild.Build{}
 	if err := json.Unmarshal(jsonData, build); err != nil {
 		return nil, xerrors.Errorf("Failed to serialize %v: %w", s.Config.Config, err)
 	}
+	s.Config.maskSensitiveSubstitutions(build.Substitutions)
+	log.WithField("json", string(jsonData)).Trace("Marshal cloudbuild.yaml to json format")
 	log.WithField("file", s.Config.Config).WithField("build", build).Trace("finished to read cloudbuild.yaml")
 
-	if len(s.Config.Substitutions) > 0 {
-		if build.Substitutions == nil {
-			build.Substitutions = make(map[string]string)
-		}
-		for _, substitution := range s.Config.Substitutions {
-			keyValue := strings.SplitN(substitution, "=", 2)
-			build.Substitutions[keyValue[0]] = keyValue[1]
-		}
+	if err := applySubstitutions(build, s.Config.Substitutions); err != nil {
+		return nil, err
+	}
+	s.Config.maskSensitiveSubstitutions(build.Substitutions)
+	if err := s.applyServiceAccount(build); err != nil {
+		return nil, err
+	}
+	if err := s.Config.applySecrets(build); err != nil {
+		return nil, err
+	}
+	if err := validateSecrets(build); err != nil {
+		return nil, err
 	}
 
 	return build, nil
 }
 
+// applySubstitutions sets key=value expressions to substitutions of the build.
+func applySubstitutions(build *cloudbuild.Build, substitutions []string) error {
+	if len(substitutions) == 0 {
+		return nil
+	}
+	if build.Substitutions == nil {
+		build.Substitutions = make(map[string]string)
+	}
+	for _, substitution := range substitutions {
+		keyValue := strings.SplitN(substitution, "=", 2)
+		if len(keyValue) != 2 {
+			return xerrors.Errorf("Invalid substitution '%v': must be key=value", substitution)
+		}
+		build.Substitutions[keyValue[0]] = keyValue[1]
+	}
+	return nil
+}
+
 func (s *CloudBuildSubmit) createSourceArchive() (io.ReadCloser, error) {
 	log.WithField("source", s.Config.SourceDir).Info("Archiving the source directory")
 	path, err := filepath.Abs(s.Config.SourceDir)
@@ -202,36 +342,50 @@ func (s *CloudBuildSubmit) createSourceArchive() (io.ReadCloser, error) {
 }
 
 func (s *CloudBuildSubmit) uploadCloudStorage(stream io.Reader) error {
-	log.WithField("gcsPath", s.sourcePath).Info("Uploading the source archive")
+	log.WithField("gcsBucket", s.sourcePath.Bucket).
+		WithField("gcsObject", s.sourcePath.Object).
+		Info("Uploading the source archive")
 	bucketName := s.sourcePath.Bucket
 	objectPath := s.sourcePath.Object
 
 	ctx := context.Background()
-	if s.Config.UploadTimeoutMsec > 0 {
+	if s.Config.UploadTimeout > 0 {
 		timeoutCtx, cancel := context.WithTimeout(
 			ctx,
-			time.Duration(s.Config.UploadTimeoutMsec)*time.Millisecond,
+			s.Config.UploadTimeout,
 		)
 		ctx = timeoutCtx
 		defer cancel()
 	}
-	client, err := storage.NewClient(ctx)
+	client, err := s.Config.newStorageClient(ctx)
 	if err != nil {
-		return xerrors.Errorf("Failed to initialize gcs client: %w", err)
+		return err
 	}
 	object := client.Bucket(bucketName).Object(objectPath)
 	writer := object.NewWriter(ctx)
-	defer writer.Close()
+	start := time.Now()
 	transferred, err := io.Copy(writer, stream)
 	if err != nil {
+		writer.Close()
+		return xerrors.Errorf("Failed to upload source archive to %v: %w", s.sourcePath, err)
+	}
+	// The upload completes and reports its failure on Close.
+	if err := writer.Close(); err != nil {
 		return xerrors.Errorf("Failed to upload source archive to %v: %w", s.sourcePath, err)
 	}
-	log.WithField("gcsPath", s.sourcePath).WithField("size", transferred).Info("Finished to upload the source archive")
+	submitMetrics.set(metricArchiveSize, float64(transferred))
+	submitMetrics.set(metricUploadDuration, time.Since(start).Seconds())
+	log.WithField("gcsBucket", s.sourcePath.Bucket).
+		WithField("gcsObject", s.sourcePath.Object).
+		WithField("size", transferred).
+		Info("Finished to upload the source archive")
 	return nil
 }
 
 func (s *CloudBuildSubmit) runCloudBuild(build *cloudbuild.Build) error {
-	log.WithField("source", s.sourcePath).Info("Queueing build")
+	log.WithField("gcsBucket", s.sourcePath.Bucket).
+		WithField("gcsObject", s.sourcePath.Object).
+		Info("Queueing build")
 	bucketName := s.sourcePath.Bucket
 	objectPath := s.sourcePath.Object
 
@@ -243,17 +397,17 @@ func (s *CloudBuildSubmit) runCloudBuild(build *cloudbuild.Build) error {
 	}
 
 	ctx := context.Background()
-	service, err := cloudbuild.NewService(ctx)
+	service, err := s.Config.newCloudBuildService(ctx)
 	if err != nil {
-		return xerrors.Errorf("Failed to create coudbuild service: %w", err)
+		return err
 	}
 	buildService := cloudbuild.NewProjectsBuildsService(service)
 	call := buildService.Create(s.Config.Project, build)
 	createCtx := ctx
-	if s.Config.CloudBuildTimeoutMsec > 0 {
+	if s.Config.CloudBuildTimeout > 0 {
 		timeoutCtx, cancel := context.WithTimeout(
 			createCtx,
-			time.Duration(s.Config.CloudBuildTimeoutMsec)*time.Millisecond,
+			s.Config.CloudBuildTimeout,
 		)
 		createCtx = timeoutCtx
 		defer cancel()
@@ -263,74 +417,80 @@ func (s *CloudBuildSubmit) runCloudBuild(build *cloudbuild.Build) error {
 		return xerrors.Errorf("Failed to queue build: %w", err)
 	}
 
+	if s.buildID, err = buildIDFromOperation(operation); err != nil {
+		return err
+	}
+	log.WithField("buildID", s.buildID).Info("Build queued")
+	return nil
+}
+
+// buildIDFromOperation extracts the id of the build from the operation to create the build.
+func buildIDFromOperation(operation *cloudbuild.Operation) (string, error) {
 	metadata := &cloudbuild.BuildOperationMetadata{}
 	if err := json.Unmarshal(operation.Metadata, &metadata); err != nil {
-		return xerrors.Errorf("Failed to parse result(%s): %w", string(operation.Metadata), err)
+		return "", xerrors.Errorf("Failed to parse result(%s): %w", string(operation.Metadata), err)
 	}
-	s.buildID = metadata.Build.Id
 	log.WithField("build", metadata).Trace("Build metadata")
-	log.WithField("buildID", s.buildID).Info("Build queued")
-	return nil
+	if metadata.Build == nil {
+		return "", xerrors.Errorf("No build in result(%s)", string(operation.Metadata))
+	}
+	return metadata.Build.Id, nil
 }
 
-func (s *CloudBuildSubmit) watchCloudBuild(buildID string) (string, error) {
-	log.WithField("source", s.sourcePath).Debug("Watching build")
+// watchCloudBuild watches the build until it completes.
+// Reads of logs are recorded as children of watchSpan.
+func (s *CloudBuildSubmit) watchCloudBuild(buildID string, watchSpan *span) (*cloudbuild.Build, error) {
+	log.WithField("buildID", buildID).Debug("Watching build")
 	ctx := context.Background()
-	service, err := cloudbuild.NewService(ctx)
+	service, err := s.Config.newCloudBuildService(ctx)
 	if err != nil {
-		return "", NewServiceError("Failed to create cloudbuild service", err)
+		return nil, err
 	}
 	buildService := cloudbuild.NewProjectsBuildsService(service)
 
 	call := buildService.Get(s.Config.Project, buildID)
-	var build *cloudbuild.Build
-	for backoff := NewBackoff(); true; {
-		if build, err = func() (*cloudbuild.Build, error) {
-			getCtx := ctx
-			if s.Config.CloudBuildTimeoutMsec > 0 {
-				timeoutCtx, cancel := context.WithTimeout(
-					getCtx,
-					time.Duration(s.Config.CloudBuildTimeoutMsec)*time.Millisecond,
-				)
-				defer cancel()
-				getCtx = timeoutCtx
-			}
-			return call.Context(getCtx).Do()
-		}(); err != nil {
-			if (s.Config.MaxGetBuildTryCount <= 0 || backoff.Attempt() < s.Config.MaxGetBuildTryCount) &&
-				isRetryableError(err) {
-				log.WithError(err).
-					WithField("build", buildID).
-					WithField("attempt", backoff.Attempt()).
-					Warning("Failed to stat build. Retrying...")
-				backoff.Sleep()
-				continue
-			}
-			return "", NewServiceError(
-				fmt.Sprintf("Failed to stat build %s", buildID),
-				err,
-			)
-		}
-		break
+	build, err := s.Config.getBuild(ctx, call, buildID)
+	if err != nil {
+		return nil, err
 	}
 	log.WithField("build", build).Trace("Stat build")
 
-	logURLStr := fmt.Sprintf("%v/log-%v.txt", build.LogsBucket, build.Id)
-	logURL, err := ParseGcsURL(logURLStr)
-	if err != nil {
-		return "", xerrors.Errorf("Invalid url '%s': %w", build.LogUrl, err)
+	var logObject *storage.ObjectHandle
+	var loggingLog *cloudLoggingLog
+	switch mode := buildLogging(build); mode {
+	case loggingNone:
+		log.WithField("buildID", build.Id).Info("Build logs are not available as logging is disabled")
+	case loggingCloudLoggingOnly, loggingStackdriverOnly:
+		log.WithField("buildID", build.Id).Debug("Reading build logs from Cloud Logging")
+		if loggingLog, err = s.Config.newCloudLoggingLog(ctx, build); err != nil {
+			return nil, err
+		}
+	default:
+		logURLStr := fmt.Sprintf("%v/log-%v.txt", build.LogsBucket, build.Id)
+		logURL, err := ParseGcsURL(logURLStr)
+		if err != nil {
+			return nil, xerrors.Errorf("Invalid url '%s': %w", build.LogUrl, err)
+		}
+		log.WithField("gcsBucket", logURL.Bucket).
+			WithField("gcsObject", logURL.Object).
+			Trace("Stat log")
+
+		gcsClient, err := s.Config.newStorageClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		logObject = gcsClient.Bucket(logURL.Bucket).Object(logURL.Object)
 	}
-	bucketName := logURL.Bucket
-	objectPath := logURL.Object
-	log.WithField("gcsBucket", bucketName).
-		WithField("gcsObject", objectPath).
-		Trace("Stat log")
 
-	gcsClient, err := storage.NewClient(ctx)
+	logWriter, err := s.openBuildLogWriter()
 	if err != nil {
-		return "", NewServiceError("Failed to initialize gcs client", err)
+		return nil, err
 	}
-	logObject := gcsClient.Bucket(bucketName).Object(objectPath)
+	defer func() {
+		if err := logWriter.Close(); err != nil {
+			log.WithError(err).Warning("Failed to close build log outputs")
+		}
+	}()
 
 	w := &watchLogStatus{
 		config:       &s.Config,
@@ -339,6 +499,9 @@ func (s *CloudBuildSubmit) watchCloudBuild(buildID string) (string, error) {
 		getBuildCall: call,
 		cbAttempt:    0,
 		logObject:    logObject,
+		loggingLog:   loggingLog,
+		logWriter:    logWriter,
+		span:         watchSpan,
 		gcsAttempt:   0,
 		offset:       0,
 		started:      false,
@@ -351,21 +514,58 @@ func (s *CloudBuildSubmit) watchCloudBuild(buildID string) (string, error) {
 
 	for !w.complete {
 		if err := w.watchLog(); err != nil {
-			return "", err
+			return nil, err
 		}
-		time.Sleep(time.Duration(s.Config.PollingIntervalMsec) * time.Millisecond)
+		time.Sleep(s.Config.PollingInterval)
+	}
+	// Cloud Build may finish writing logs after the build completes.
+	// Read the rest of logs once more to have the complete log.
+	if err := w.readRestLog(); err != nil {
+		return nil, err
 	}
 	build = w.build
 	s.completeStatus = build.Status
 	log.WithField("build", build).
-		WithField("gcsBucket", logURL.Bucket).
-		WithField("gcsObject", logURL.Object).
 		WithField("logSize", w.offset).
 		Debug("Finished to watch build")
 	log.WithField("buildID", build.Id).
 		WithField("status", build.Status).
 		Info("Build completed")
-	return s.completeStatus, nil
+	return build, nil
+}
+
+// getBuild gets the build retrying for errors.
+func (c *Config) getBuild(
+	ctx context.Context,
+	call *cloudbuild.ProjectsBuildsGetCall,
+	buildID string,
+) (*cloudbuild.Build, error) {
+	var build *cloudbuild.Build
+	var err error
+	for backoff := NewBackoff(); true; {
+		if build, err = func() (*cloudbuild.Build, error) {
+			getCtx, cancel := c.cloudBuildContext(ctx)
+			defer cancel()
+			return call.Context(getCtx).Do()
+		}(); err != nil {
+			if (c.MaxGetBuildTryCount <= 0 || backoff.Attempt() < c.MaxGetBuildTryCount) &&
+				isRetryableError(err) {
+				recordRetry("stat build", err)
+				log.WithError(err).
+					WithField("buildID", buildID).
+					WithField("attempt", backoff.Attempt()).
+					Warning("Failed to stat build. Retrying...")
+				backoff.Sleep()
+				continue
+			}
+			return nil, NewServiceError(
+				fmt.Sprintf("Failed to stat build %s", buildID),
+				err,
+			)
+		}
+		break
+	}
+	return build, nil
 }
 
 type watchLogStatus struct {
@@ -375,20 +575,30 @@ type watchLogStatus struct {
 	getBuildCall *cloudbuild.ProjectsBuildsGetCall
 	cbAttempt    int
 	logObject    *storage.ObjectHandle
-	offset       int64
-	gcsAttempt   int
-	started      bool
-	complete     bool
+	loggingLog   *cloudLoggingLog
+	// loggingAttempt is the number of failed reads from Cloud Logging in a row.
+	loggingAttempt int
+	// loggingNextRead is the time to read from Cloud Logging next.
+	loggingNextRead time.Time
+	// loggingBackoff is the interval to read from Cloud Logging backed off for exceeding the quota.
+	loggingBackoff time.Duration
+	logWriter      io.Writer
+	// span is the span to record reads of logs as its children.
+	span       *span
+	offset     int64
+	gcsAttempt int
+	started    bool
+	complete   bool
 }
 
 func (w *watchLogStatus) watchLog() error {
 	w.cbAttempt++
 	if newBuild, err := func() (*cloudbuild.Build, error) {
 		getCtx := w.ctx
-		if w.config.CloudBuildTimeoutMsec > 0 {
+		if w.config.CloudBuildTimeout > 0 {
 			timeoutCtx, cancel := context.WithTimeout(
 				getCtx,
-				time.Duration(w.config.CloudBuildTimeoutMsec)*time.Millisecond,
+				w.config.CloudBuildTimeout,
 			)
 			defer cancel()
 			getCtx = timeoutCtx
@@ -402,6 +612,7 @@ func (w *watchLogStatus) watchLog() error {
 				err,
 			)
 		}
+		recordRetry("stat build", err)
 		log.WithError(err).
 			WithField("buildID", w.build.Id).
 			WithField("attempt", w.cbAttempt).
@@ -419,13 +630,42 @@ func (w *watchLogStatus) watchLog() error {
 		w.started = true
 	}
 
+	if err := w.readLog(); err != nil {
+		return err
+	}
+
+	if isBuildCompleted(w.build.Status) {
+		log.WithField("build", w.build).Trace("Build completed")
+		w.complete = true
+	}
+
+	return nil
+}
+
+// readLog reads logs written after the last read.
+func (w *watchLogStatus) readLog() error {
+	switch {
+	case w.logObject != nil:
+		return w.readGcsLog()
+	case w.loggingLog != nil:
+		return w.readCloudLoggingLog()
+	}
+	return nil
+}
+
+// readGcsLog reads logs written to Cloud Storage after the last read.
+func (w *watchLogStatus) readGcsLog() error {
 	w.gcsAttempt++
-	if count, err := func() (int64, error) {
+	readSpan := w.span.startChild("readLog").
+		setAttribute("source", "gcs").
+		setAttribute("attempt", w.gcsAttempt).
+		setAttribute("offset", w.offset)
+	count, err := func() (int64, error) {
 		readCtx := w.ctx
-		if w.config.ReadLogTimeoutMsec > 0 {
+		if w.config.ReadLogTimeout > 0 {
 			timeoutCtx, cancel := context.WithTimeout(
 				readCtx,
-				time.Duration(w.config.ReadLogTimeoutMsec)*time.Millisecond,
+				w.config.ReadLogTimeout,
 			)
 			defer cancel()
 			readCtx = timeoutCtx
@@ -435,8 +675,14 @@ func (w *watchLogStatus) watchLog() error {
 			return int64(0), err
 		}
 		defer reader.Close()
-		return io.Copy(os.Stdout, reader)
-	}(); err != nil {
+		return io.Copy(w.logWriter, reader)
+	}()
+	if isIgnorableGcsError(err) {
+		readSpan.setAttribute("size", count).finish(nil)
+	} else {
+		readSpan.setAttribute("size", count).finish(err)
+	}
+	if err != nil {
 		if !isIgnorableGcsError(err) {
 			if (w.config.MaxReadLogTryCount > 0 && w.gcsAttempt >= w.config.MaxReadLogTryCount) ||
 				!isRetryableError(err) {
@@ -445,6 +691,7 @@ func (w *watchLogStatus) watchLog() error {
 					err,
 				)
 			}
+			recordRetry("read log", err)
 			log.WithError(err).
 				WithField("gcsBucket", w.logObject.BucketName()).
 				WithField("gcsObject", w.logObject.ObjectName()).
@@ -466,12 +713,102 @@ func (w *watchLogStatus) watchLog() error {
 		w.gcsAttempt = 0
 		w.offset += count
 	}
+	return nil
+}
 
-	if isBuildCompleted(w.build.Status) {
-		log.WithField("build", w.build).Trace("Build completed")
-		w.complete = true
+// readRestLog reads logs written after the last read once the build completes.
+// It retries until the read succeeds as the log would be incomplete otherwise,
+// and fails with the last error when reads fail MaxReadLogTryCount times in a row.
+func (w *watchLogStatus) readRestLog() error {
+	switch {
+	case w.logObject != nil:
+		backoff := NewBackoff()
+		for {
+			if err := w.readGcsLog(); err != nil {
+				return err
+			}
+			if w.gcsAttempt == 0 {
+				return nil
+			}
+			backoff.Sleep()
+		}
+	case w.loggingLog != nil:
+		// Wait for Cloud Logging to ingest the last logs.
+		time.Sleep(cloudLoggingIngestionDelay)
+		for {
+			w.loggingNextRead = time.Time{}
+			if err := w.readCloudLoggingLog(); err != nil {
+				return err
+			}
+			if w.loggingAttempt == 0 {
+				return nil
+			}
+			time.Sleep(time.Until(w.loggingNextRead))
+		}
 	}
+	return nil
+}
 
+// readCloudLoggingLog reads logs written to Cloud Logging after the last read.
+// Reads are less frequent than polling builds not to exceed the quota of Cloud Logging.
+func (w *watchLogStatus) readCloudLoggingLog() error {
+	now := time.Now()
+	if now.Before(w.loggingNextRead) {
+		return nil
+	}
+	interval := cloudLoggingPollingInterval
+	if w.config.PollingInterval > interval {
+		interval = w.config.PollingInterval
+	}
+	w.loggingAttempt++
+	readSpan := w.span.startChild("readLog").
+		setAttribute("source", "cloudLogging").
+		setAttribute("attempt", w.loggingAttempt).
+		setAttribute("offset", w.offset)
+	count, err := func() (int64, error) {
+		readCtx := w.ctx
+		if w.config.ReadLogTimeout > 0 {
+			timeoutCtx, cancel := context.WithTimeout(
+				readCtx,
+				w.config.ReadLogTimeout,
+			)
+			defer cancel()
+			readCtx = timeoutCtx
+		}
+		return w.loggingLog.read(readCtx, w.logWriter)
+	}()
+	readSpan.setAttribute("size", count).finish(err)
+	w.offset += count
+	if err != nil {
+		if (w.config.MaxReadLogTryCount > 0 && w.loggingAttempt >= w.config.MaxReadLogTryCount) ||
+			!isRetryableError(err) {
+			return NewServiceError(
+				"Failed to read log",
+				err,
+			)
+		}
+		if isQuotaExceededError(err) {
+			if w.loggingBackoff < interval {
+				w.loggingBackoff = interval
+			}
+			w.loggingBackoff *= 2
+			if w.loggingBackoff > cloudLoggingMaxPollingInterval {
+				w.loggingBackoff = cloudLoggingMaxPollingInterval
+			}
+			interval = w.loggingBackoff
+		}
+		recordRetry("read log", err)
+		log.WithError(err).
+			WithField("buildID", w.loggingLog.buildID).
+			WithField("attempt", w.loggingAttempt).
+			WithField("interval", interval).
+			Warn("Failed to read log")
+		w.loggingNextRead = now.Add(interval)
+		return nil
+	}
+	w.loggingAttempt = 0
+	w.loggingBackoff = 0
+	w.loggingNextRead = now.Add(interval)
 	return nil
 }
 
@@ -491,6 +828,52 @@ func isBuildCompleted(status string) bool {
 		status == "CANCELLED"
 }
 
+// timeSpanDuration returns the duration between timestamps returned from Cloud Build.
+// Returns false if either of timestamps is not available.
+func timeSpanDuration(startTime, endTime string) (time.Duration, bool) {
+	if startTime == "" || endTime == "" {
+		return 0, false
+	}
+	start, err := time.Parse(time.RFC3339Nano, startTime)
+	if err != nil {
+		return 0, false
+	}
+	end, err := time.Parse(time.RFC3339Nano, endTime)
+	if err != nil {
+		return 0, false
+	}
+	return end.Sub(start), true
+}
+
+// cancel requests Cloud Build to cancel the build.
+func (s *CloudBuildSubmit) cancel(buildID string) error {
+	ctx := context.Background()
+	service, err := s.Config.newCloudBuildService(ctx)
+	if err != nil {
+		return err
+	}
+	buildService := cloudbuild.NewProjectsBuildsService(service)
+	return s.Config.cancelBuild(ctx, buildService, buildID)
+}
+
+// cancelStarted cancels the started build recording the span.
+func (s *CloudBuildSubmit) cancelStarted(buildID string) error {
+	log.WithField("buildID", buildID).
+		Info("Canceling build...")
+
+	cancelSpan := s.span.startChild("cancel").setAttribute("buildID", buildID)
+	err := s.cancel(buildID)
+	cancelSpan.finish(err)
+	// The process may exit soon after canceled.
+	s.tracer.flush()
+	if err != nil {
+		return err
+	}
+	log.WithField("buildID", buildID).
+		Info("Canceled")
+	return nil
+}
+
 // Cancel cancels running build
 func (s *CloudBuildSubmit) Cancel() error {
 	if s.buildID == "" {
@@ -503,43 +886,39 @@ func (s *CloudBuildSubmit) Cancel() error {
 			Debug("No need to cancel build as build has already completed.")
 		return nil
 	}
-	log.WithField("buildID", s.buildID).
-		Info("Canceling build...")
+	return s.cancelStarted(s.buildID)
+}
 
-	ctx := context.Background()
-	service, err := cloudbuild.NewService(ctx)
-	if err != nil {
-		return xerrors.Errorf("Failed to create coudbuild service: %w", err)
-	}
-	cancel := &cloudbuild.CancelBuildRequest{}
-	buildService := cloudbuild.NewProjectsBuildsService(service)
-	call := buildService.Cancel(s.Project, s.buildID, cancel)
-	createCtx := ctx
-	if s.Config.CloudBuildTimeoutMsec > 0 {
-		timeoutCtx, cancel := context.WithTimeout(
-			createCtx,
-			time.Duration(s.Config.CloudBuildTimeoutMsec)*time.Millisecond,
-		)
-		createCtx = timeoutCtx
-		defer cancel()
-	}
+// cancelBuild cancels the build retrying for errors.
+func (c *Config) cancelBuild(
+	ctx context.Context,
+	buildService *cloudbuild.ProjectsBuildsService,
+	buildID string,
+) error {
+	call := buildService.Cancel(c.Project, buildID, &cloudbuild.CancelBuildRequest{})
 	for backoff := NewBackoff(); true; {
-		if _, err := call.Context(createCtx).Do(); err != nil {
+		if err := func() error {
+			cancelCtx, cancel := c.cloudBuildContext(ctx)
+			defer cancel()
+			_, err := call.Context(cancelCtx).Do()
+			return err
+		}(); err != nil {
 			if googleapi.IsNotModified(err) {
 				break
 			}
-			if (s.Config.MaxStartBuildTryCount <= 0 || backoff.Attempt() < s.Config.MaxStartBuildTryCount) &&
+			if (c.MaxStartBuildTryCount <= 0 || backoff.Attempt() < c.MaxStartBuildTryCount) &&
 				isRetryableError(err) {
-				log.WithError(err).WithField("attempt", backoff.Attempt()).
+				recordRetry("cancel build", err)
+				log.WithError(err).
+					WithField("buildID", buildID).
+					WithField("attempt", backoff.Attempt()).
 					Warning("Failed to cancel build. Retrying...")
 				backoff.Sleep()
 				continue
 			}
-			return xerrors.Errorf("Failed to cancel build %v: %w", s.buildID, err)
+			return xerrors.Errorf("Failed to cancel build %v: %w", buildID, err)
 		}
 		break
 	}
-	log.WithField("buildID", s.buildID).
-		Info("Canceled")
 	return nil
 }
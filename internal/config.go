@@ -4,60 +4,202 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
-	"github.com/ikedam/cloudbuild/log"
+	"google.golang.org/api/option"
 
-	"golang.org/x/oauth2/google"
+	"github.com/ikedam/cloudbuild/log"
 )
 
 // Config holds the configuration for cloudbuild
 type Config struct {
 	// SourceDir is the source directory to archive.
-	SourceDir string
+	SourceDir string `mapstructure:"sourceDir"`
 
 	// Project is the ID of Google Cloud Project
-	Project string
+	Project string `mapstructure:"project"`
 
 	// GcsSourceStagingDir is the directory on the Google Cloud Storage
 	// to upload source archives.
-	GcsSourceStagingDir string
+	GcsSourceStagingDir string `mapstructure:"gcsSourceStagingDir"`
 
 	// IgnoreFile is the ignore file to use instead of .gcloudignore
-	IgnoreFile string
+	IgnoreFile string `mapstructure:"ignoreFile"`
 
 	// Config is the file to use instead of cloudbuild.yaml
-	Config string
+	Config string `mapstructure:"config"`
 
 	// Substitutions is the key=value expressions to replace keywords in cloudbuild.yaml
-	Substitutions []string
+	Substitutions []string `mapstructure:"substitutions"`
 
-	// PollingIntervalMsec is the interval for polling build statuses and logs.
-	PollingIntervalMsec int
+	// PollingInterval is the interval for polling build statuses and logs.
+	PollingInterval time.Duration `mapstructure:"pollingInterval"`
 
-	// UploadTimeoutMsec is the milliseconds to consider the upload is timed out.
-	UploadTimeoutMsec int
+	// UploadTimeout is the duration to consider the upload is timed out. 0 is infinite
+	UploadTimeout time.Duration `mapstructure:"uploadTimeout"`
 
 	// MaxUploadTryCount is the maximum number to give up uploading source arvhive. 0 is infinite
-	MaxUploadTryCount int
+	MaxUploadTryCount int `mapstructure:"maxUploadTryCount"`
 
-	// CloudBuildTimeoutMsec is the millieseconds to consider Cloud Build operations are timed out.
-	CloudBuildTimeoutMsec int
+	// CloudBuildTimeout is the duration to consider Cloud Build operations are timed out. 0 is infinite
+	CloudBuildTimeout time.Duration `mapstructure:"cloudBuildTimeout"`
 
 	// MaxStartBuildTryCount is the maximum number to give up starting Cloud Build. 0 is infinite
-	MaxStartBuildTryCount int
+	MaxStartBuildTryCount int `mapstructure:"maxStartBuildTryCount"`
 
 	// MaxGetBuildTryCount is the maximum number to give up to get build informations. 0 is infinite
-	MaxGetBuildTryCount int
+	MaxGetBuildTryCount int `mapstructure:"maxGetBuildTryCount"`
 
-	// ReadLogTimeoutMsec is the milliseconds to consider fetching logs from Cloud Storage is timed out.
-	ReadLogTimeoutMsec int
+	// ReadLogTimeout is the duration to consider fetching logs from Cloud Storage is timed out. 0 is infinite
+	ReadLogTimeout time.Duration `mapstructure:"readLogTimeout"`
 
 	// MaxReadLogErrorCount is the maximum number to give up to read logs. 0 is infinite
-	MaxReadLogTryCount int
+	MaxReadLogTryCount int `mapstructure:"maxReadLogTryCount"`
+
+	// LogFile is the file to save build logs to.
+	LogFile string `mapstructure:"logFile"`
+
+	// QuietBuildLog suppresses printing build logs to the console.
+	QuietBuildLog bool `mapstructure:"quietBuildLog"`
+
+	// ColorBuildLog colorizes prefixes of steps in build logs printed to the console.
+	ColorBuildLog bool `mapstructure:"colorBuildLog"`
+
+	// BuildLogTimestamps prefixes build logs printed to the console with the received time.
+	BuildLogTimestamps bool `mapstructure:"buildLogTimestamps"`
+
+	// Steps is the ids or indexes of steps to print logs to the console. Empty for all steps.
+	Steps []string `mapstructure:"steps"`
+
+	// FailedStepLogLines is the number of last lines of logs of failed steps
+	// to print again when the build failed. 0 not to print.
+	FailedStepLogLines int `mapstructure:"failedStepLogLines"`
+
+	// CIIntegration is the CI service to integrate with: auto, github or gitlab. Empty to disable.
+	CIIntegration string `mapstructure:"ciIntegration"`
+
+	// CIDotenvFile is the dotenv file to write the build result for GitLab CI/CD.
+	CIDotenvFile string `mapstructure:"ciDotenvFile"`
+
+	// JUnitReport is the file to write the JUnit report of steps to.
+	JUnitReport string `mapstructure:"junitReport"`
+
+	// ChangedSince is the git ref to compare the source directory with
+	// to skip builds without relevant changes. Empty not to skip builds.
+	ChangedSince string `mapstructure:"changedSince"`
+
+	// Notifiers is the destinations to notify results of builds.
+	Notifiers []*NotifierConfig `mapstructure:"notifiers"`
+
+	// CredentialsFile is the JSON credentials file to use instead of
+	// the application default credentials.
+	CredentialsFile string `mapstructure:"credentialsFile"`
+
+	// AccessTokenFile is the file containing the OAuth2 access token to use.
+	// The file is read every time a token is required.
+	AccessTokenFile string `mapstructure:"accessTokenFile"`
+
+	// ImpersonateServiceAccount is the service account to impersonate.
+	ImpersonateServiceAccount string `mapstructure:"impersonateServiceAccount"`
+
+	// ServiceAccount is the service account to run builds as.
+	// Either an email or projects/PROJECT/serviceAccounts/EMAIL.
+	ServiceAccount string `mapstructure:"serviceAccount"`
+
+	// Secrets is NAME=VERSION expressions of Secret Manager secrets
+	// to add to availableSecrets of builds.
+	Secrets []string `mapstructure:"secrets"`
+
+	// SensitiveSubstitutions is keys of substitutions whose values are masked in logs.
+	SensitiveSubstitutions []string `mapstructure:"sensitiveSubstitutions"`
+
+	// TraceEndpoint is the OTLP/HTTP endpoint to export spans of submissions to.
+	// Tracing is disabled if empty.
+	TraceEndpoint string `mapstructure:"traceEndpoint"`
+
+	// MetricsFile is the file to write metrics of submissions to in OpenMetrics text format.
+	MetricsFile string `mapstructure:"metricsFile"`
+
+	// Pushgateway is the URL of Prometheus Pushgateway to push metrics of submissions to.
+	Pushgateway string `mapstructure:"pushgateway"`
+
+	// extraClientOptions is appended to options of clients.
+	// Tests use it to access local servers instead of Google Cloud.
+	extraClientOptions []option.ClientOption
+
+	// credentialsCache is the credentials resolved for the config.
+	credentialsCache *credentialsCache
+}
+
+// DurationSetting describes a setting of a duration.
+type DurationSetting struct {
+	// Key is the key of the setting.
+	Key string
+	// DeprecatedMsecKey is the deprecated key of the setting in milliseconds.
+	DeprecatedMsecKey string
+	// Default is the default value of the setting.
+	Default time.Duration
+	// Min is the minimum value of the setting.
+	Min time.Duration
+	// Max is the maximum value of the setting.
+	Max time.Duration
+	// AllowZero allows 0 to disable the timeout.
+	AllowZero bool
+}
+
+// DurationSettings is settings of durations.
+var DurationSettings = []*DurationSetting{
+	{
+		Key:               "pollingInterval",
+		DeprecatedMsecKey: "pollingIntervalMsec",
+		Default:           time.Second,
+		Min:               100 * time.Millisecond,
+		Max:               10 * time.Minute,
+	},
+	{
+		Key:               "uploadTimeout",
+		DeprecatedMsecKey: "uploadTimeoutMsec",
+		Default:           5 * time.Minute,
+		Min:               time.Second,
+		Max:               24 * time.Hour,
+		AllowZero:         true,
+	},
+	{
+		Key:               "cloudBuildTimeout",
+		DeprecatedMsecKey: "cloudBuildTimeoutMsec",
+		Default:           10 * time.Second,
+		Min:               time.Second,
+		Max:               time.Hour,
+		AllowZero:         true,
+	},
+	{
+		Key:               "readLogTimeout",
+		DeprecatedMsecKey: "readLogTimeoutMsec",
+		Default:           30 * time.Second,
+		Min:               time.Second,
+		Max:               time.Hour,
+		AllowZero:         true,
+	},
+}
+
+// Check checks the value is in the range of the setting.
+func (s *DurationSetting) Check(value time.Duration) error {
+	if value == 0 && s.AllowZero {
+		return nil
+	}
+	if value < s.Min || value > s.Max {
+		message := fmt.Sprintf("%v must be between %v and %v", s.Key, s.Min, s.Max)
+		if s.AllowZero {
+			message = fmt.Sprintf("%v, or 0 to disable", message)
+		}
+		return NewConfigError(fmt.Sprintf("%v: %v", message, value), nil)
+	}
+	return nil
 }
 
 // ResolveDefaults fills default values for configurations.
 func (c *Config) ResolveDefaults() error {
+	c.maskSensitiveSubstitutions(substitutionMap(c.Substitutions))
 	if err := c.resolveProject(); err != nil {
 		return err
 	}
@@ -67,6 +209,20 @@ func (c *Config) ResolveDefaults() error {
 	return nil
 }
 
+// cloudBuildContext returns the context with the timeout for Cloud Build operations.
+func (c *Config) cloudBuildContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.CloudBuildTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.CloudBuildTimeout)
+}
+
+// useBuildLogRenderer returns whether build logs printed to the console
+// need processed line by line.
+func (c *Config) useBuildLogRenderer() bool {
+	return c.ColorBuildLog || c.BuildLogTimestamps || len(c.Steps) > 0
+}
+
 func (c *Config) resolveProject() error {
 	if c.Project != "" {
 		log.Debug("Using the configured project")
@@ -80,16 +236,16 @@ func (c *Config) resolveProject() error {
 	}
 
 	ctx := context.Background()
-	cred, err := google.FindDefaultCredentials(ctx)
+	cred, err := c.credentials(ctx)
 	if err != nil {
-		return NewConfigError("Failed to get default credentials", err)
+		return err
 	}
-	if cred.ProjectID == "" {
+	if cred.projectID == "" {
 		return NewConfigError("No projectId is configured. Please set GOOGLE_PROJECT_ID.", nil)
 	}
 
-	c.Project = cred.ProjectID
-	log.Debug("Using the project of the default credentials")
+	c.Project = cred.projectID
+	log.WithField("credentials", cred.source).Debug("Using the project of the credentials")
 
 	return nil
 }
@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/xerrors"
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+)
+
+// CloudBuildDescribe holds running state of describing a build
+type CloudBuildDescribe struct {
+	Config
+
+	// BuildID is the id of the build to describe.
+	BuildID string
+
+	// Output is the output format: text, json or yaml.
+	Output string
+}
+
+// Execute gets the build and prints it
+func (d *CloudBuildDescribe) Execute() error {
+	if d.Output != OutputFormatText && d.Output != OutputFormatJSON && d.Output != OutputFormatYAML {
+		return NewConfigError(fmt.Sprintf("Unsupported output format '%v'", d.Output), nil)
+	}
+	if err := d.Config.resolveProject(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	service, err := d.Config.newCloudBuildService(ctx)
+	if err != nil {
+		return err
+	}
+	buildService := cloudbuild.NewProjectsBuildsService(service)
+	build, err := d.Config.getBuild(ctx, buildService.Get(d.Config.Project, d.BuildID), d.BuildID)
+	if err != nil {
+		return err
+	}
+	d.Config.maskSensitiveSubstitutions(build.Substitutions)
+	build.Substitutions = maskedSubstitutions(build.Substitutions)
+
+	switch d.Output {
+	case OutputFormatJSON:
+		return printJSON(os.Stdout, build)
+	case OutputFormatYAML:
+		return printYAML(os.Stdout, build)
+	}
+	return printBuildDetails(os.Stdout, build)
+}
+
+// printBuildDetails prints the build in human readable format.
+func printBuildDetails(out io.Writer, build *cloudbuild.Build) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%v\n", build.Id)
+	status := build.Status
+	if build.StatusDetail != "" {
+		status = fmt.Sprintf("%v (%v)", status, build.StatusDetail)
+	}
+	fmt.Fprintf(w, "Status:\t%v\n", status)
+	if build.FailureInfo != nil {
+		fmt.Fprintf(w, "Failure:\t%v: %v\n", build.FailureInfo.Type, build.FailureInfo.Detail)
+	}
+	fmt.Fprintf(w, "Project:\t%v\n", build.ProjectId)
+	if build.BuildTriggerId != "" {
+		fmt.Fprintf(w, "Trigger:\t%v\n", build.BuildTriggerId)
+	}
+	fmt.Fprintf(w, "Create time:\t%v\n", formatBuildTime(build.CreateTime))
+	fmt.Fprintf(w, "Start time:\t%v\n", formatBuildTime(build.StartTime))
+	fmt.Fprintf(w, "Finish time:\t%v\n", formatBuildTime(build.FinishTime))
+	fmt.Fprintf(w, "Duration:\t%v\n", formatDuration(build.StartTime, build.FinishTime))
+	if build.Timeout != "" {
+		fmt.Fprintf(w, "Timeout:\t%v\n", build.Timeout)
+	}
+	fmt.Fprintf(w, "Source:\t%v\n", describeSource(build.Source))
+	fmt.Fprintf(w, "Tags:\t%v\n", formatList(build.Tags))
+	if build.ServiceAccount != "" {
+		fmt.Fprintf(w, "Service account:\t%v\n", build.ServiceAccount)
+	}
+	fmt.Fprintf(w, "Log URL:\t%v\n", build.LogUrl)
+	if err := w.Flush(); err != nil {
+		return xerrors.Errorf("Failed to print build: %w", err)
+	}
+
+	if len(build.Substitutions) > 0 {
+		fmt.Fprintln(out, "\nSubstitutions:")
+		keys := make([]string, 0, len(build.Substitutions))
+		for key := range build.Substitutions {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		for _, key := range keys {
+			fmt.Fprintf(w, "  %v:\t%v\n", key, build.Substitutions[key])
+		}
+		if err := w.Flush(); err != nil {
+			return xerrors.Errorf("Failed to print build: %w", err)
+		}
+	}
+
+	if len(build.Steps) > 0 {
+		fmt.Fprintln(out, "\nSteps:")
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  #\tID\tNAME\tSTATUS\tSTART_TIME\tDURATION")
+		for idx, step := range build.Steps {
+			startTime, duration := "-", "-"
+			if step.Timing != nil {
+				startTime = formatBuildTime(step.Timing.StartTime)
+				duration = formatDuration(step.Timing.StartTime, step.Timing.EndTime)
+			}
+			id := step.Id
+			if id == "" {
+				id = "-"
+			}
+			fmt.Fprintf(w, "  %v\t%v\t%v\t%v\t%v\t%v\n", idx, id, step.Name, step.Status, startTime, duration)
+		}
+		if err := w.Flush(); err != nil {
+			return xerrors.Errorf("Failed to print build: %w", err)
+		}
+	}
+
+	if len(build.Images) > 0 {
+		fmt.Fprintln(out, "\nImages:")
+		for _, image := range build.Images {
+			fmt.Fprintf(out, "  %v\n", image)
+		}
+	}
+
+	if build.Results != nil {
+		if images := builtImages(build); len(images) > 0 {
+			fmt.Fprintln(out, "\nResults:")
+			for _, image := range images {
+				fmt.Fprintf(out, "  %v\n", image)
+			}
+		}
+	}
+
+	if build.Options != nil {
+		body, err := toYAML(build.Options)
+		if err != nil {
+			return err
+		}
+		if options := strings.TrimSpace(string(body)); options != "{}" {
+			fmt.Fprintln(out, "\nOptions:")
+			for _, line := range strings.Split(options, "\n") {
+				fmt.Fprintf(out, "  %v\n", line)
+			}
+		}
+	}
+	return nil
+}
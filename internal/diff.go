@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContextLines is the number of unchanged lines printed around changes.
+const diffContextLines = 3
+
+// unifiedDiff returns differences of texts in the unified diff format.
+// Returns an empty string if there're no differences.
+func unifiedDiff(fromName, toName, from, to string) string {
+	fromLines := splitLines(from)
+	toLines := splitLines(to)
+	ops := diffLines(fromLines, toLines)
+
+	changed := false
+	for _, op := range ops {
+		if op.kind != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %v\n", fromName)
+	fmt.Fprintf(&b, "+++ %v\n", toName)
+	// fromLine and toLine are the numbers of lines before ops[start] in from and to.
+	fromLine, toLine := 0, 0
+	for start := 0; start < len(ops); {
+		// find the next change
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first >= len(ops) {
+			break
+		}
+		// extend the hunk while changes are close to each other
+		last := first
+		for idx := first; idx < len(ops) && idx <= last+2*diffContextLines; idx++ {
+			if ops[idx].kind != ' ' {
+				last = idx
+			}
+		}
+		hunkStart := first - diffContextLines
+		if hunkStart < start {
+			hunkStart = start
+		}
+		hunkEnd := last + diffContextLines + 1
+		if hunkEnd > len(ops) {
+			hunkEnd = len(ops)
+		}
+		for _, op := range ops[start:hunkStart] {
+			fromLine, toLine = op.advance(fromLine, toLine)
+		}
+		hunk := ops[hunkStart:hunkEnd]
+		fromCount, toCount := 0, 0
+		for _, op := range hunk {
+			fromCount, toCount = op.advance(fromCount, toCount)
+		}
+		fmt.Fprintf(
+			&b,
+			"@@ -%v +%v @@\n",
+			hunkRange(fromLine, fromCount),
+			hunkRange(toLine, toCount),
+		)
+		for _, op := range hunk {
+			fmt.Fprintf(&b, "%c%v\n", op.kind, op.line)
+		}
+		fromLine += fromCount
+		toLine += toCount
+		start = hunkEnd
+	}
+	return b.String()
+}
+
+// hunkRange returns the range of lines in the hunk header.
+// before is the number of lines before the hunk.
+// The range of an empty hunk starts at the line before it.
+func hunkRange(before, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%v,0", before)
+	}
+	return fmt.Sprintf("%v,%v", before+1, count)
+}
+
+type diffOp struct {
+	// kind is ' ' for unchanged lines, '-' for removed lines and '+' for added lines.
+	kind byte
+	line string
+}
+
+// advance counts the line of the operation in from and to.
+func (op diffOp) advance(fromLine, toLine int) (int, int) {
+	switch op.kind {
+	case '-':
+		return fromLine + 1, toLine
+	case '+':
+		return fromLine, toLine + 1
+	}
+	return fromLine + 1, toLine + 1
+}
+
+// diffLines computes differences of lines with the longest common subsequence.
+func diffLines(from, to []string) []diffOp {
+	// lcs[i][j] is the length of the longest common subsequence of from[i:] and to[j:]
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := []diffOp{}
+	i, j := 0, 0
+	for i < len(from) && j < len(to) {
+		switch {
+		case from[i] == to[j]:
+			ops = append(ops, diffOp{' ', from[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', from[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', to[j]})
+			j++
+		}
+	}
+	for ; i < len(from); i++ {
+		ops = append(ops, diffOp{'-', from[i]})
+	}
+	for ; j < len(to); j++ {
+		ops = append(ops, diffOp{'+', to[j]})
+	}
+	return ops
+}
+
+func splitLines(text string) []string {
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(text, "\n")
+}
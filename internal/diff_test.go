@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func numberedLines(from, to int) string {
+	var b strings.Builder
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(&b, "line%v\n", i)
+	}
+	return b.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	testcases := []struct {
+		name     string
+		from     string
+		to       string
+		expected string
+	}{
+		{
+			name:     "same",
+			from:     "a\nb\n",
+			to:       "a\nb\n",
+			expected: "",
+		},
+		{
+			name: "change",
+			from: numberedLines(1, 10),
+			to:   strings.Replace(numberedLines(1, 10), "line5\n", "changed\n", 1),
+			expected: strings.Join([]string{
+				"--- from",
+				"+++ to",
+				"@@ -2,7 +2,7 @@",
+				" line2",
+				" line3",
+				" line4",
+				"-line5",
+				"+changed",
+				" line6",
+				" line7",
+				" line8",
+				"",
+			}, "\n"),
+		},
+		{
+			name: "two hunks",
+			from: numberedLines(1, 20),
+			to:   "line1\nadded\n" + numberedLines(2, 18) + numberedLines(20, 20),
+			expected: strings.Join([]string{
+				"--- from",
+				"+++ to",
+				"@@ -1,4 +1,5 @@",
+				" line1",
+				"+added",
+				" line2",
+				" line3",
+				" line4",
+				"@@ -16,5 +17,4 @@",
+				" line16",
+				" line17",
+				" line18",
+				"-line19",
+				" line20",
+				"",
+			}, "\n"),
+		},
+		{
+			name: "create",
+			from: "",
+			to:   "a\nb\n",
+			expected: strings.Join([]string{
+				"--- from",
+				"+++ to",
+				"@@ -0,0 +1,2 @@",
+				"+a",
+				"+b",
+				"",
+			}, "\n"),
+		},
+		{
+			name: "delete",
+			from: "a\nb\n",
+			to:   "",
+			expected: strings.Join([]string{
+				"--- from",
+				"+++ to",
+				"@@ -1,2 +0,0 @@",
+				"-a",
+				"-b",
+				"",
+			}, "\n"),
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := unifiedDiff("from", "to", tc.from, tc.to)
+			if actual != tc.expected {
+				t.Errorf("expected:\n%v\ngot:\n%v", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
 
+	"github.com/ikedam/cloudbuild/log"
 	"golang.org/x/xerrors"
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
 	"google.golang.org/api/googleapi"
 )
 
@@ -25,6 +28,10 @@ const (
 	ExitCodeResultTimeout = 13
 	// ExitCodeResultCancelled is the exit code for cancelled builds
 	ExitCodeResultCancelled = 14
+	// ExitCodeResultStepTimeout is the exit code for builds failed as steps timed out
+	ExitCodeResultStepTimeout = 15
+	// ExitCodeSkipped is the exit code for builds skipped as no relevant files changed.
+	ExitCodeSkipped = 16
 	// ExitCodeUnexpectedError is the exit code for unexpected errors.
 	ExitCodeUnexpectedError = 100
 	// ExitCodeConfigurationError is the exit code for configuration errors.
@@ -89,14 +96,67 @@ func NewServiceError(message string, cause error) error {
 	}
 }
 
+// SkippedError indicates the build is skipped as it's not necessary.
+type SkippedError struct {
+	// Reason is the reason to skip the build.
+	Reason string
+}
+
+func (err *SkippedError) Error() string {
+	return fmt.Sprintf("Build skipped: %v", err.Reason)
+}
+
+// NewSkippedError creates a new SkippedError
+func NewSkippedError(reason string) error {
+	return &SkippedError{
+		Reason: reason,
+	}
+}
+
+// FailedStep describes a step failed in a build.
+// It has no exit code of the step as the Cloud Build API (v1) doesn't provide it.
+// FailureInfo of the build may mention it in the detail.
+type FailedStep struct {
+	// Index is the index of the step in the build.
+	Index int
+	// ID is the id of the step. Empty if the step has no id.
+	ID string
+	// Name is the name (the container image) of the step.
+	Name string
+	// Status is the status of the step, e.g. FAILURE or TIMEOUT.
+	Status string
+}
+
+func (step *FailedStep) String() string {
+	if step.ID != "" {
+		return fmt.Sprintf("Step #%v - %q (%v): %v", step.Index, step.ID, step.Name, step.Status)
+	}
+	return fmt.Sprintf("Step #%v (%v): %v", step.Index, step.Name, step.Status)
+}
+
 // BuildResultError indicates build failures.
 type BuildResultError struct {
 	BuildID string
 	Status  string
+	// FailedSteps is steps failed in the build.
+	FailedSteps []*FailedStep
+	// FailureInfo is the details of the failure reported by Cloud Build. Can be nil.
+	FailureInfo *cloudbuild.FailureInfo
 }
 
 func (err *BuildResultError) Error() string {
-	return fmt.Sprintf("Build %v failed with %v", err.BuildID, err.Status)
+	message := fmt.Sprintf("Build %v failed with %v", err.BuildID, err.Status)
+	if len(err.FailedSteps) > 0 {
+		steps := make([]string, 0, len(err.FailedSteps))
+		for _, step := range err.FailedSteps {
+			steps = append(steps, step.String())
+		}
+		message = fmt.Sprintf("%v: %v", message, strings.Join(steps, ", "))
+	}
+	if err.FailureInfo != nil && err.FailureInfo.Detail != "" {
+		message = fmt.Sprintf("%v (%v: %v)", message, err.FailureInfo.Type, err.FailureInfo.Detail)
+	}
+	return message
 }
 
 // NewBuildResultError create a new BuildResultError
@@ -107,12 +167,52 @@ func NewBuildResultError(buildID, status string) error {
 	}
 }
 
+// NewBuildResultErrorForBuild creates a new BuildResultError with details of the failed build.
+func NewBuildResultErrorForBuild(build *cloudbuild.Build) error {
+	return &BuildResultError{
+		BuildID:     build.Id,
+		Status:      build.Status,
+		FailedSteps: failedSteps(build),
+		FailureInfo: build.FailureInfo,
+	}
+}
+
+// failedSteps returns steps failed in the build.
+// Steps cancelled or not run because of other failed steps are not included.
+func failedSteps(build *cloudbuild.Build) []*FailedStep {
+	steps := []*FailedStep{}
+	for idx, step := range build.Steps {
+		if step.Status != "FAILURE" && step.Status != "TIMEOUT" && step.Status != "INTERNAL_ERROR" {
+			continue
+		}
+		steps = append(steps, &FailedStep{
+			Index:  idx,
+			ID:     step.Id,
+			Name:   step.Name,
+			Status: step.Status,
+		})
+	}
+	return steps
+}
+
 // ExitCodeForError returns the exit code appropriate for the passed error.
 func ExitCodeForError(err error) int {
 	var e1 *BuildResultError
 	if xerrors.As(err, &e1) {
+		if e1.Status == "FAILURE" {
+			for _, step := range e1.FailedSteps {
+				if step.Status == "TIMEOUT" {
+					// Timeout of a step makes the build fail, not time out.
+					return ExitCodeResultStepTimeout
+				}
+			}
+		}
 		return ExitCodeForStatus(e1.Status)
 	}
+	var skippedError *SkippedError
+	if xerrors.As(err, &skippedError) {
+		return ExitCodeSkipped
+	}
 	var e2 *ConfigError
 	if xerrors.As(err, &e2) {
 		return ExitCodeConfigurationError
@@ -227,3 +327,24 @@ func (b *Backoff) Sleep() {
 func (b *Backoff) Attempt() int {
 	return b.attempt
 }
+
+// retryWithBackoff calls f until it succeeds.
+// It gives up when f returns a non-retryable error or f fails maxTryCount times (0 is infinite).
+// operation is used for logging like "Failed to <operation>. Retrying...".
+func retryWithBackoff(maxTryCount int, operation string, f func() error) error {
+	for backoff := NewBackoff(); true; {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if (maxTryCount <= 0 || backoff.Attempt() < maxTryCount) && isRetryableError(err) {
+			recordRetry(operation, err)
+			log.WithError(err).WithField("attempt", backoff.Attempt()).
+				Warningf("Failed to %v. Retrying...", operation)
+			backoff.Sleep()
+			continue
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+
+	"github.com/ikedam/cloudbuild/log"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name          `xml:"testsuites"`
+	Suites  []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Errors    int              `xml:"errors,attr"`
+	Skipped   int              `xml:"skipped,attr"`
+	Time      string           `xml:"time,attr"`
+	Timestamp string           `xml:"timestamp,attr,omitempty"`
+	TestCases []*junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitResult  `xml:"failure,omitempty"`
+	Error     *junitResult  `xml:"error,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitResult struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// stepLogCollector collects logs for each step.
+type stepLogCollector struct {
+	logs map[int]*strings.Builder
+}
+
+func newStepLogCollector() *stepLogCollector {
+	return &stepLogCollector{
+		logs: make(map[int]*strings.Builder),
+	}
+}
+
+func (c *stepLogCollector) handleLine(line *buildLogLine) error {
+	if line.Step < 0 {
+		return nil
+	}
+	b, ok := c.logs[line.Step]
+	if !ok {
+		b = &strings.Builder{}
+		c.logs[line.Step] = b
+	}
+	b.WriteString(line.Text)
+	b.WriteString("\n")
+	return nil
+}
+
+// Log returns the collected logs of the step.
+func (c *stepLogCollector) Log(step int) string {
+	if b, ok := c.logs[step]; ok {
+		return b.String()
+	}
+	return ""
+}
+
+// junitSeconds formats the duration in seconds for JUnit reports.
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// newJUnitReport creates a JUnit report treating each step of the build as a test case.
+// logs can be nil if logs are not collected.
+func newJUnitReport(suiteName string, build *cloudbuild.Build, logs *stepLogCollector) *junitTestSuites {
+	suite := &junitTestSuite{
+		Name:      suiteName,
+		Tests:     len(build.Steps),
+		Time:      junitSeconds(0),
+		Timestamp: build.StartTime,
+	}
+	if duration, ok := timeSpanDuration(build.StartTime, build.FinishTime); ok {
+		suite.Time = junitSeconds(duration)
+	}
+	for idx, step := range build.Steps {
+		testCase := &junitTestCase{
+			Name:      stepDisplayName(idx, step),
+			ClassName: suiteName,
+			Time:      junitSeconds(0),
+		}
+		if step.Timing != nil {
+			if duration, ok := timeSpanDuration(step.Timing.StartTime, step.Timing.EndTime); ok {
+				testCase.Time = junitSeconds(duration)
+			}
+		}
+		switch step.Status {
+		case "SUCCESS":
+		case "FAILURE", "TIMEOUT":
+			suite.Failures++
+			testCase.Failure = &junitResult{
+				Message: fmt.Sprintf("Step finished with %v", step.Status),
+				Type:    step.Status,
+			}
+		case "INTERNAL_ERROR":
+			suite.Errors++
+			testCase.Error = &junitResult{
+				Message: fmt.Sprintf("Step finished with %v", step.Status),
+				Type:    step.Status,
+			}
+		default:
+			suite.Skipped++
+			testCase.Skipped = &junitSkipped{
+				Message: fmt.Sprintf("Step finished with %v", step.Status),
+			}
+		}
+		if logs != nil {
+			testCase.SystemOut = logs.Log(idx)
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+	return &junitTestSuites{
+		Suites: []*junitTestSuite{suite},
+	}
+}
+
+// stepDisplayName returns the name of the step in the same format as build logs.
+func stepDisplayName(idx int, step *cloudbuild.BuildStep) string {
+	if step.Id != "" {
+		return fmt.Sprintf("Step #%v - %q", idx, step.Id)
+	}
+	return fmt.Sprintf("Step #%v", idx)
+}
+
+// writeJUnitReport writes the JUnit report of the build to the configured file.
+func (s *CloudBuildSubmit) writeJUnitReport(build *cloudbuild.Build) error {
+	report := newJUnitReport(s.Config.Config, build, s.stepLogCollector)
+	body, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("Failed to serialize the JUnit report: %w", err)
+	}
+	body = append([]byte(xml.Header), body...)
+	body = append(body, '\n')
+	if err := ioutil.WriteFile(s.Config.JUnitReport, body, 0644); err != nil {
+		return xerrors.Errorf("Failed to write %v: %w", s.Config.JUnitReport, err)
+	}
+	log.WithField("file", s.Config.JUnitReport).Debug("Wrote the JUnit report")
+	return nil
+}
@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/xerrors"
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+
+	"github.com/ikedam/cloudbuild/log"
+)
+
+// listPageSize is the maximum number of builds to retrieve in a request.
+const listPageSize = 100
+
+// BuildListOptions is the conditions to list builds.
+type BuildListOptions struct {
+	// Statuses is the statuses of builds to list. Empty for any statuses.
+	Statuses []string
+
+	// TriggerID is the id of the trigger started builds to list.
+	TriggerID string
+
+	// Tags is the tags builds to list have.
+	Tags []string
+
+	// Since lists builds created after this. Accepts a duration like 24h or a RFC3339 timestamp.
+	Since string
+
+	// Until lists builds created before this. Accepts a duration like 24h or a RFC3339 timestamp.
+	Until string
+
+	// Filter is the filter expression of Cloud Build passed as it is.
+	Filter string
+
+	// Limit is the maximum number of builds to list. 0 is infinite.
+	Limit int
+
+	// Output is the output format: table or json.
+	Output string
+}
+
+// CloudBuildList holds running state of listing builds
+type CloudBuildList struct {
+	Config
+	BuildListOptions
+}
+
+// Execute lists builds and prints them
+func (l *CloudBuildList) Execute() error {
+	if err := l.Config.resolveProject(); err != nil {
+		return err
+	}
+	if l.Output != OutputFormatTable && l.Output != OutputFormatJSON {
+		return NewConfigError(fmt.Sprintf("Unsupported output format '%v'", l.Output), nil)
+	}
+	filter, err := l.BuildListOptions.filterExpression(time.Now())
+	if err != nil {
+		return NewConfigError("Invalid conditions to list builds", err)
+	}
+	builds, err := l.Config.listBuilds(filter, l.Limit)
+	if err != nil {
+		return err
+	}
+	if l.Output == OutputFormatJSON {
+		return printJSON(os.Stdout, builds)
+	}
+	return printBuildTable(builds)
+}
+
+// filterExpression returns the filter expression for Cloud Build API.
+// See https://cloud.google.com/build/docs/view-build-results#filtering_build_results_using_queries
+func (o *BuildListOptions) filterExpression(now time.Time) (string, error) {
+	conditions := []string{}
+	if len(o.Statuses) > 0 {
+		statuses := make([]string, 0, len(o.Statuses))
+		for _, status := range o.Statuses {
+			statuses = append(statuses, fmt.Sprintf("status=%q", strings.ToUpper(status)))
+		}
+		conditions = append(conditions, fmt.Sprintf("(%v)", strings.Join(statuses, " OR ")))
+	}
+	if o.TriggerID != "" {
+		conditions = append(conditions, fmt.Sprintf("trigger_id=%q", o.TriggerID))
+	}
+	for _, tag := range o.Tags {
+		conditions = append(conditions, fmt.Sprintf("tags=%q", tag))
+	}
+	if o.Since != "" {
+		since, err := parseTimeOrAgo(o.Since, now)
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, fmt.Sprintf("create_time>=%q", since.UTC().Format(time.RFC3339)))
+	}
+	if o.Until != "" {
+		until, err := parseTimeOrAgo(o.Until, now)
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, fmt.Sprintf("create_time<=%q", until.UTC().Format(time.RFC3339)))
+	}
+	if o.Filter != "" {
+		conditions = append(conditions, fmt.Sprintf("(%v)", o.Filter))
+	}
+	return strings.Join(conditions, " AND "), nil
+}
+
+// parseTimeOrAgo parses a RFC3339 timestamp, or a duration before now.
+func parseTimeOrAgo(value string, now time.Time) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, xerrors.Errorf("'%v' is neither a duration nor a RFC3339 timestamp: %w", value, err)
+	}
+	return t, nil
+}
+
+// listBuilds lists builds matching the filter expression.
+// limit is the maximum number of builds to list. 0 is infinite.
+func (c *Config) listBuilds(filter string, limit int) ([]*cloudbuild.Build, error) {
+	log.WithField("filter", filter).Debug("Listing builds")
+	ctx := context.Background()
+	service, err := c.newCloudBuildService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	buildService := cloudbuild.NewProjectsBuildsService(service)
+
+	builds := []*cloudbuild.Build{}
+	pageToken := ""
+	for {
+		call := buildService.List(c.Project).PageToken(pageToken)
+		if filter != "" {
+			call = call.Filter(filter)
+		}
+		pageSize := listPageSize
+		if limit > 0 && limit-len(builds) < pageSize {
+			pageSize = limit - len(builds)
+		}
+		call = call.PageSize(int64(pageSize))
+		var response *cloudbuild.ListBuildsResponse
+		if err := retryWithBackoff(c.MaxGetBuildTryCount, "list builds", func() error {
+			listCtx, cancel := c.cloudBuildContext(ctx)
+			defer cancel()
+			var err error
+			response, err = call.Context(listCtx).Do()
+			return err
+		}); err != nil {
+			return nil, NewServiceError("Failed to list builds", err)
+		}
+		builds = append(builds, response.Builds...)
+		if limit > 0 && len(builds) >= limit {
+			builds = builds[:limit]
+			break
+		}
+		if response.NextPageToken == "" {
+			break
+		}
+		pageToken = response.NextPageToken
+	}
+	log.WithField("count", len(builds)).Debug("Listed builds")
+	return builds, nil
+}
+
+// printBuildTable prints builds in a table.
+func printBuildTable(builds []*cloudbuild.Build) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tCREATE_TIME\tDURATION\tSOURCE\tTAGS")
+	for _, build := range builds {
+		fmt.Fprintf(
+			w,
+			"%v\t%v\t%v\t%v\t%v\t%v\n",
+			build.Id,
+			build.Status,
+			formatBuildTime(build.CreateTime),
+			formatDuration(build.StartTime, build.FinishTime),
+			describeSource(build.Source),
+			formatList(build.Tags),
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return xerrors.Errorf("Failed to print builds: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,507 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"golang.org/x/xerrors"
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ikedam/cloudbuild/log"
+)
+
+// BuildSpec is a build to start with submit-many.
+type BuildSpec struct {
+	// Name is the name of the build used to prefix logs and in the result table.
+	Name string `yaml:"name"`
+
+	// Config is the path to cloudbuild.yaml for the build.
+	Config string `yaml:"config"`
+
+	// Substitutions is the substitutions specific to the build.
+	Substitutions map[string]string `yaml:"substitutions"`
+
+	// DependsOn is the names of builds to wait for before the build starts.
+	DependsOn []string `yaml:"dependsOn"`
+
+	// cell is the combination of the build matrix the build is expanded for.
+	cell map[string]string
+}
+
+// buildManifest is the file format listing builds for submit-many and pipeline.
+type buildManifest struct {
+	Builds []*BuildSpec `yaml:"builds"`
+}
+
+// ReadBuildManifest reads builds from the manifest file.
+func ReadBuildManifest(path string) ([]*BuildSpec, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, NewConfigError(fmt.Sprintf("Failed to read %v", path), err)
+	}
+	manifest := &buildManifest{}
+	if err := yaml.Unmarshal(body, manifest); err != nil {
+		return nil, NewConfigError(fmt.Sprintf("Failed to parse %v", path), err)
+	}
+	return manifest.Builds, nil
+}
+
+// CloudBuildSubmitMany holds running state of submitting multiple builds
+// for one source archive.
+type CloudBuildSubmitMany struct {
+	Config
+
+	// Builds is the builds to start.
+	Builds []*BuildSpec
+
+	// Concurrency is the maximum number of builds running at the same time.
+	// Not limited if 0 or less.
+	Concurrency int
+
+	// Matrix is the build matrix to run each build for. No matrix if nil.
+	Matrix *BuildMatrix
+
+	// tracer records spans of the submission. nil if tracing is disabled.
+	tracer *tracer
+	// span is the span of the whole submission.
+	span *span
+
+	mutex sync.Mutex
+	// running is ids of started builds not finished yet.
+	// Submits are registered after their builds start,
+	// and Cancel reads only ids recorded here not to race with workers.
+	running map[*CloudBuildSubmit]string
+	// canceled is set when the submission is canceled not to start more builds.
+	canceled bool
+}
+
+// syncWriter serializes writes from multiple builds.
+type syncWriter struct {
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.out.Write(p)
+}
+
+// Execute uploads the source once and runs builds in parallel.
+// Builds wait for builds in DependsOn, and are not run if any of them failed.
+// Returns the error of the worst build, that is the one with the largest exit code.
+func (m *CloudBuildSubmitMany) Execute() error {
+	var err error
+	if m.tracer, err = m.Config.newTracer(); err != nil {
+		return err
+	}
+	m.span = m.tracer.startSpan("submit-many", nil).setAttribute("builds", len(m.Builds))
+	err = m.execute()
+	m.span.finish(err)
+	m.tracer.flush()
+	m.Config.exportMetrics()
+	return err
+}
+
+func (m *CloudBuildSubmitMany) execute() error {
+	if len(m.Builds) == 0 {
+		return NewConfigError("No builds to submit", nil)
+	}
+	if err := m.validateBuilds(); err != nil {
+		return err
+	}
+	if err := m.validateDependencies(); err != nil {
+		return err
+	}
+	matrixKeys := []string{}
+	if m.Matrix != nil {
+		for _, spec := range m.Builds {
+			if len(spec.DependsOn) > 0 {
+				return NewConfigError("dependsOn cannot be used with the build matrix", nil)
+			}
+		}
+		var err error
+		if m.Builds, err = expandMatrix(m.Builds, m.Matrix); err != nil {
+			return err
+		}
+		if err := m.validateBuilds(); err != nil {
+			return err
+		}
+		matrixKeys = m.Matrix.Keys()
+	}
+
+	// Resolve credentials once for all builds.
+	m.Config.shareCredentials()
+	parent := &CloudBuildSubmit{
+		Config: m.Config,
+		tracer: m.tracer,
+		span:   m.span,
+	}
+	var err error
+	if parent.ci, err = newCIIntegration(&parent.Config); err != nil {
+		return err
+	}
+	if err := parent.prepareSourcePath(); err != nil {
+		return err
+	}
+
+	console := &syncWriter{out: os.Stdout}
+	width := 0
+	for _, spec := range m.Builds {
+		if len(spec.Name) > width {
+			width = len(spec.Name)
+		}
+	}
+	submits := make([]*CloudBuildSubmit, 0, len(m.Builds))
+	for _, spec := range m.Builds {
+		submit := &CloudBuildSubmit{
+			Config:     m.Config,
+			sourcePath: parent.sourcePath,
+			console:    console,
+			logPrefix:  fmt.Sprintf("[%-*v] ", width, spec.Name),
+			tracer:     m.tracer,
+			name:       spec.Name,
+		}
+		submit.Config.Config = spec.Config
+		submit.Config.Substitutions = append([]string{}, m.Config.Substitutions...)
+		submit.Config.Substitutions = append(submit.Config.Substitutions, specSubstitutions(spec)...)
+		submit.Config.LogFile = perBuildFileName(m.Config.LogFile, spec.Name)
+		submit.Config.JUnitReport = perBuildFileName(m.Config.JUnitReport, spec.Name)
+		// The result of all builds is reported at last.
+		submit.Config.CIIntegration = CIIntegrationNone
+		submits = append(submits, submit)
+	}
+
+	var changedFiles []string
+	if m.Config.ChangedSince != "" {
+		if changedFiles, err = m.Config.changedFiles(); err != nil {
+			return err
+		}
+	}
+
+	// Read all configurations before uploading not to start only a part of builds.
+	builds := make([]*buildToStart, 0, len(submits))
+	skippedAll := true
+	for idx, submit := range submits {
+		build, err := submit.readCloudBuild()
+		if err != nil {
+			return NewConfigError(
+				fmt.Sprintf("Failed to read %v for %v", submit.Config.Config, m.Builds[idx].Name),
+				err,
+			)
+		}
+		toStart := &buildToStart{
+			spec:   m.Builds[idx],
+			submit: submit,
+			build:  build,
+			done:   make(chan struct{}),
+		}
+		if m.Config.ChangedSince != "" {
+			toStart.skipped = submit.skipForChanges(changedFiles)
+		}
+		if toStart.skipped != nil {
+			log.WithField("name", toStart.spec.Name).
+				WithField("reason", toStart.skipped).
+				Info("Build skipped")
+		} else {
+			skippedAll = false
+		}
+		builds = append(builds, toStart)
+	}
+	if skippedAll {
+		return NewSkippedError(fmt.Sprintf("All builds are skipped since %v", m.Config.ChangedSince))
+	}
+	buildsByName := make(map[string]*buildToStart, len(builds))
+	for _, toStart := range builds {
+		buildsByName[toStart.spec.Name] = toStart
+	}
+	for _, toStart := range builds {
+		for _, dependency := range toStart.spec.DependsOn {
+			toStart.dependencies = append(toStart.dependencies, buildsByName[dependency])
+		}
+	}
+
+	if err := parent.uploadSource(); err != nil {
+		return err
+	}
+
+	concurrency := m.Concurrency
+	if concurrency <= 0 {
+		concurrency = len(builds)
+	}
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, toStart := range builds {
+		wg.Add(1)
+		go func(toStart *buildToStart) {
+			defer wg.Done()
+			defer close(toStart.done)
+			if !toStart.waitDependencies() {
+				log.WithField("name", toStart.spec.Name).Warning("Build is not run as its dependencies failed")
+				toStart.notRun = true
+				return
+			}
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			toStart.err = m.runBuild(toStart)
+		}(toStart)
+	}
+	wg.Wait()
+
+	results := make([]*buildResult, 0, len(builds))
+	for _, toStart := range builds {
+		results = append(results, &buildResult{
+			spec:    toStart.spec,
+			submit:  toStart.submit,
+			err:     toStart.err,
+			skipped: toStart.skipped != nil,
+			notRun:  toStart.notRun,
+		})
+	}
+	if err := printBuildResultTable(results, matrixKeys); err != nil {
+		log.WithError(err).Warning("Failed to print results")
+	}
+
+	if parent.ci != nil {
+		for _, result := range results {
+			if result.submit.result == nil {
+				continue
+			}
+			if err := parent.ci.reportResult(result.spec.Name, result.submit.result); err != nil {
+				log.WithError(err).Warning("Failed to report the result to the CI service")
+			}
+		}
+	}
+
+	return worstBuildError(results)
+}
+
+// buildToStart is a build ready to start.
+type buildToStart struct {
+	spec   *BuildSpec
+	submit *CloudBuildSubmit
+	build  *cloudbuild.Build
+	// skipped is SkippedError if the build is skipped for no relevant changes.
+	skipped error
+	// dependencies is the builds to wait for before the build starts.
+	dependencies []*buildToStart
+	// done is closed when the build finishes or is not run.
+	done chan struct{}
+	// err is the error of the build.
+	err error
+	// notRun is set if the build is not run as its dependencies failed.
+	notRun bool
+}
+
+// buildResult is the result of a build started with submit-many.
+type buildResult struct {
+	spec    *BuildSpec
+	submit  *CloudBuildSubmit
+	err     error
+	skipped bool
+	notRun  bool
+}
+
+// validateBuilds checks builds have unique names and configurations.
+func (m *CloudBuildSubmitMany) validateBuilds() error {
+	names := make(map[string]bool)
+	for idx, spec := range m.Builds {
+		if spec.Config == "" {
+			return NewConfigError(fmt.Sprintf("config is not specified for build #%v", idx), nil)
+		}
+		if spec.Name == "" {
+			spec.Name = spec.Config
+		}
+		if names[spec.Name] {
+			return NewConfigError(fmt.Sprintf("Duplicate build name '%v'", spec.Name), nil)
+		}
+		names[spec.Name] = true
+	}
+	return nil
+}
+
+// runBuild starts the build and watches it.
+func (m *CloudBuildSubmitMany) runBuild(toStart *buildToStart) error {
+	if toStart.skipped != nil {
+		return nil
+	}
+	if err := toStart.resolveReferences(); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	canceled := m.canceled
+	m.mutex.Unlock()
+	if canceled {
+		return NewBuildResultError("", "CANCELLED")
+	}
+
+	toStart.submit.span = m.span.startChild("build " + toStart.spec.Name)
+	err := func() error {
+		if err := toStart.submit.startBuild(toStart.build); err != nil {
+			return err
+		}
+		buildID := toStart.submit.buildID
+		log.WithField("name", toStart.spec.Name).
+			WithField("buildID", buildID).
+			Info("Build started")
+		if m.registerRunning(toStart.submit, buildID) {
+			defer m.unregisterRunning(toStart.submit)
+		} else {
+			// Canceled while starting the build.
+			// Watch it anyway to report the result.
+			if err := toStart.submit.cancelStarted(buildID); err != nil {
+				log.WithError(err).WithField("buildID", buildID).Error("Failed to cancel build")
+			}
+		}
+		return toStart.submit.watchAndReport()
+	}()
+	toStart.submit.span.finish(err)
+	return err
+}
+
+// registerRunning records the started build to cancel.
+// Returns false without recording if the submission is already canceled.
+func (m *CloudBuildSubmitMany) registerRunning(submit *CloudBuildSubmit, buildID string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.canceled {
+		return false
+	}
+	if m.running == nil {
+		m.running = make(map[*CloudBuildSubmit]string)
+	}
+	m.running[submit] = buildID
+	return true
+}
+
+// unregisterRunning removes the finished build.
+func (m *CloudBuildSubmitMany) unregisterRunning(submit *CloudBuildSubmit) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.running, submit)
+}
+
+// Cancel cancels all running builds and prevents pending builds from starting.
+// Builds starting at the time are canceled by their workers once started.
+func (m *CloudBuildSubmitMany) Cancel() error {
+	m.mutex.Lock()
+	m.canceled = true
+	running := make(map[*CloudBuildSubmit]string, len(m.running))
+	for submit, buildID := range m.running {
+		running[submit] = buildID
+	}
+	m.mutex.Unlock()
+
+	var lastErr error
+	for submit, buildID := range running {
+		if err := submit.cancelStarted(buildID); err != nil {
+			log.WithError(err).WithField("buildID", buildID).Error("Failed to cancel build")
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// specSubstitutions returns substitutions of the build in key=value expressions.
+func specSubstitutions(spec *BuildSpec) []string {
+	keys := make([]string, 0, len(spec.Substitutions))
+	for key := range spec.Substitutions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	substitutions := make([]string, 0, len(keys))
+	for _, key := range keys {
+		substitutions = append(substitutions, fmt.Sprintf("%v=%v", key, spec.Substitutions[key]))
+	}
+	return substitutions
+}
+
+// perBuildFileName inserts the name of the build into the file name
+// not to have builds overwrite files of each other.
+// e.g. build.log is build-api.log for the build named api.
+func perBuildFileName(path string, name string) string {
+	if path == "" {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%v-%v%v", strings.TrimSuffix(path, ext), sanitizeBuildName(name), ext)
+}
+
+// sanitizeBuildName replaces characters of the build name
+// other than alphanumerics, '-' and '_' with '_'
+// to use it in file names and names of CI outputs.
+func sanitizeBuildName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// printBuildResultTable prints results of builds in a table.
+// Values of matrixKeys are printed in columns for builds of the build matrix.
+func printBuildResultTable(results []*buildResult, matrixKeys []string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := append([]string{"NAME"}, matrixKeys...)
+	fmt.Fprintf(w, "%v\tBUILD_ID\tSTATUS\tDURATION\n", strings.Join(header, "\t"))
+	for _, result := range results {
+		columns := []string{result.spec.Name}
+		for _, key := range matrixKeys {
+			value, ok := result.spec.cell[key]
+			if !ok {
+				value = "-"
+			}
+			columns = append(columns, value)
+		}
+		buildID := result.submit.buildID
+		if buildID == "" {
+			buildID = "-"
+		}
+		status := "-"
+		duration := "-"
+		if result.skipped {
+			status = "SKIPPED"
+		} else if result.notRun {
+			status = "NOT_RUN"
+		} else if result.submit.result != nil {
+			status = result.submit.result.Status
+			duration = formatDuration(result.submit.result.StartTime, result.submit.result.FinishTime)
+		} else if result.err != nil {
+			var buildResultError *BuildResultError
+			if xerrors.As(result.err, &buildResultError) {
+				status = buildResultError.Status
+			} else {
+				status = "ERROR"
+			}
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", strings.Join(columns, "\t"), buildID, status, duration)
+	}
+	return w.Flush()
+}
+
+// worstBuildError returns the error with the largest exit code.
+func worstBuildError(results []*buildResult) error {
+	var worst *buildResult
+	for _, result := range results {
+		if result.err == nil {
+			continue
+		}
+		if worst == nil || ExitCodeForError(result.err) > ExitCodeForError(worst.err) {
+			worst = result
+		}
+	}
+	if worst == nil {
+		return nil
+	}
+	return xerrors.Errorf("Build %v failed: %w", worst.spec.Name, worst.err)
+}
@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+)
+
+// MatrixAxis is an axis of a build matrix.
+type MatrixAxis struct {
+	// Key is the substitution key of the axis.
+	Key string
+
+	// Values is the values of the substitution.
+	Values []string
+}
+
+// BuildMatrix is combinations of substitution values to run builds for.
+type BuildMatrix struct {
+	// Axes is the axes to compute the cartesian product of.
+	Axes matrixAxes `yaml:"axes"`
+
+	// Include is the combinations to add to the cartesian product.
+	Include []map[string]string `yaml:"include"`
+
+	// Exclude is the combinations to remove from the cartesian product.
+	// Combinations matching all values of an entry are removed.
+	Exclude []map[string]string `yaml:"exclude"`
+}
+
+// matrixAxes is the list of axes preserving the order in YAML.
+type matrixAxes []*MatrixAxis
+
+// UnmarshalYAML reads axes from the mapping of keys to values.
+func (axes *matrixAxes) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return xerrors.Errorf("line %v: axes must be a mapping of keys to lists of values", value.Line)
+	}
+	for idx := 0; idx+1 < len(value.Content); idx += 2 {
+		axis := &MatrixAxis{}
+		if err := value.Content[idx].Decode(&axis.Key); err != nil {
+			return err
+		}
+		if err := value.Content[idx+1].Decode(&axis.Values); err != nil {
+			return err
+		}
+		*axes = append(*axes, axis)
+	}
+	return nil
+}
+
+// ReadBuildMatrix reads the build matrix from the file.
+func ReadBuildMatrix(path string) (*BuildMatrix, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, NewConfigError(fmt.Sprintf("Failed to read %v", path), err)
+	}
+	matrix := &BuildMatrix{}
+	if err := yaml.Unmarshal(body, matrix); err != nil {
+		return nil, NewConfigError(fmt.Sprintf("Failed to parse %v", path), err)
+	}
+	return matrix, nil
+}
+
+// AddAxis adds an axis in KEY=VALUE1,VALUE2,... expression.
+func (m *BuildMatrix) AddAxis(expression string) error {
+	keyValues := strings.SplitN(expression, "=", 2)
+	if len(keyValues) != 2 || keyValues[0] == "" {
+		return NewConfigError(
+			fmt.Sprintf("Invalid axis '%v': must be KEY=VALUE1,VALUE2,...", expression),
+			nil,
+		)
+	}
+	m.Axes = append(m.Axes, &MatrixAxis{
+		Key:    keyValues[0],
+		Values: strings.Split(keyValues[1], ","),
+	})
+	return nil
+}
+
+// Keys returns substitution keys used in the matrix in the order of axes.
+// Keys only in include follow in the sorted order.
+func (m *BuildMatrix) Keys() []string {
+	keys := []string{}
+	known := make(map[string]bool)
+	for _, axis := range m.Axes {
+		if !known[axis.Key] {
+			keys = append(keys, axis.Key)
+			known[axis.Key] = true
+		}
+	}
+	extraKeys := []string{}
+	for _, include := range m.Include {
+		for key := range include {
+			if !known[key] {
+				extraKeys = append(extraKeys, key)
+				known[key] = true
+			}
+		}
+	}
+	sort.Strings(extraKeys)
+	return append(keys, extraKeys...)
+}
+
+// Combinations expands the matrix to combinations of substitution values.
+func (m *BuildMatrix) Combinations() ([]map[string]string, error) {
+	for _, axis := range m.Axes {
+		if len(axis.Values) == 0 {
+			return nil, NewConfigError(fmt.Sprintf("No values for axis %v", axis.Key), nil)
+		}
+	}
+
+	combinations := []map[string]string{}
+	if len(m.Axes) > 0 {
+		combinations = append(combinations, map[string]string{})
+	}
+	for _, axis := range m.Axes {
+		expanded := make([]map[string]string, 0, len(combinations)*len(axis.Values))
+		for _, combination := range combinations {
+			for _, value := range axis.Values {
+				next := make(map[string]string, len(combination)+1)
+				for k, v := range combination {
+					next[k] = v
+				}
+				next[axis.Key] = value
+				expanded = append(expanded, next)
+			}
+		}
+		combinations = expanded
+	}
+
+	filtered := make([]map[string]string, 0, len(combinations))
+	for _, combination := range combinations {
+		excluded := false
+		for _, exclude := range m.Exclude {
+			if matchesCombination(combination, exclude) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			filtered = append(filtered, combination)
+		}
+	}
+
+	for _, include := range m.Include {
+		duplicated := false
+		for _, combination := range filtered {
+			if len(combination) == len(include) && matchesCombination(combination, include) {
+				duplicated = true
+				break
+			}
+		}
+		if !duplicated {
+			filtered = append(filtered, include)
+		}
+	}
+
+	if len(filtered) == 0 {
+		return nil, NewConfigError("No combinations in the matrix", nil)
+	}
+	return filtered, nil
+}
+
+// matchesCombination tests all values of the condition match the combination.
+func matchesCombination(combination map[string]string, condition map[string]string) bool {
+	for key, value := range condition {
+		if v, ok := combination[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// expandMatrix creates builds for each combination of the matrix.
+func expandMatrix(specs []*BuildSpec, matrix *BuildMatrix) ([]*BuildSpec, error) {
+	combinations, err := matrix.Combinations()
+	if err != nil {
+		return nil, err
+	}
+	keys := matrix.Keys()
+	expanded := make([]*BuildSpec, 0, len(specs)*len(combinations))
+	for _, spec := range specs {
+		for _, combination := range combinations {
+			values := make([]string, 0, len(keys))
+			for _, key := range keys {
+				if value, ok := combination[key]; ok {
+					values = append(values, value)
+				}
+			}
+			name := strings.Join(values, "/")
+			if len(specs) > 1 {
+				name = fmt.Sprintf("%v/%v", spec.Name, name)
+			}
+			substitutions := make(map[string]string, len(spec.Substitutions)+len(combination))
+			for k, v := range spec.Substitutions {
+				substitutions[k] = v
+			}
+			for k, v := range combination {
+				substitutions[k] = v
+			}
+			expanded = append(expanded, &BuildSpec{
+				Name:          name,
+				Config:        spec.Config,
+				Substitutions: substitutions,
+				cell:          combination,
+			})
+		}
+	}
+	return expanded, nil
+}
@@ -0,0 +1,292 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/api/googleapi"
+
+	"github.com/ikedam/cloudbuild/log"
+)
+
+// pushTimeout is the timeout to push metrics to the Pushgateway.
+const pushTimeout = 30 * time.Second
+
+const (
+	// metricTypeGauge is the type of metrics describing the last submission.
+	metricTypeGauge = "gauge"
+	// metricTypeCounter is the type of metrics counting events in the last submission.
+	// Samples of counters are suffixed with _total.
+	metricTypeCounter = "counter"
+)
+
+// metricDefinition describes a metric of submissions.
+// Metrics describe the last submission rather than accumulating across submissions,
+// which suits both the textfile collector and the Pushgateway.
+type metricDefinition struct {
+	// name is the name of the metric family.
+	name       string
+	metricType string
+	help       string
+	labels     []string
+}
+
+// sampleName returns the name of samples of the metric.
+func (m *metricDefinition) sampleName() string {
+	if m.metricType == metricTypeCounter {
+		return m.name + "_total"
+	}
+	return m.name
+}
+
+var (
+	metricArchiveSize = &metricDefinition{
+		name:       "cloudbuild_source_archive_size_bytes",
+		metricType: metricTypeGauge,
+		help:       "Size of the uploaded source archive.",
+	}
+	metricUploadDuration = &metricDefinition{
+		name:       "cloudbuild_upload_duration_seconds",
+		metricType: metricTypeGauge,
+		help:       "Duration to upload the source archive.",
+	}
+	metricRetries = &metricDefinition{
+		name:       "cloudbuild_retries",
+		metricType: metricTypeCounter,
+		help:       "Number of retries of operations by the error code.",
+		labels:     []string{"operation", "code"},
+	}
+	metricQueueDuration = &metricDefinition{
+		name:       "cloudbuild_queue_duration_seconds",
+		metricType: metricTypeGauge,
+		help:       "Duration from the build is created until it starts.",
+		labels:     []string{"build"},
+	}
+	metricBuildDuration = &metricDefinition{
+		name:       "cloudbuild_build_duration_seconds",
+		metricType: metricTypeGauge,
+		help:       "Duration of the build.",
+		labels:     []string{"build"},
+	}
+	metricBuildStatus = &metricDefinition{
+		name:       "cloudbuild_build_status",
+		metricType: metricTypeGauge,
+		help:       "Final status of the build with value 1.",
+		labels:     []string{"build", "status"},
+	}
+	metricLastRun = &metricDefinition{
+		name:       "cloudbuild_last_run_timestamp_seconds",
+		metricType: metricTypeGauge,
+		help:       "Time the submission finished.",
+	}
+
+	// metricDefinitions is all metrics in the order of outputs.
+	metricDefinitions = []*metricDefinition{
+		metricArchiveSize,
+		metricUploadDuration,
+		metricRetries,
+		metricQueueDuration,
+		metricBuildDuration,
+		metricBuildStatus,
+		metricLastRun,
+	}
+)
+
+// metricSample is a value of the metric with label values.
+type metricSample struct {
+	labelValues []string
+	value       float64
+}
+
+// metricsRegistry holds values of metrics of the submission.
+type metricsRegistry struct {
+	mutex   sync.Mutex
+	samples map[*metricDefinition]map[string]*metricSample
+}
+
+// submitMetrics is metrics of the submission in this process.
+// A process runs only one submission, and retries happen deep in helpers shared with other commands.
+var submitMetrics = &metricsRegistry{}
+
+// set sets the value of the metric.
+func (r *metricsRegistry) set(metric *metricDefinition, value float64, labelValues ...string) {
+	r.update(metric, labelValues, func(float64) float64 { return value })
+}
+
+// add adds the value to the metric.
+func (r *metricsRegistry) add(metric *metricDefinition, value float64, labelValues ...string) {
+	r.update(metric, labelValues, func(current float64) float64 { return current + value })
+}
+
+func (r *metricsRegistry) update(metric *metricDefinition, labelValues []string, f func(float64) float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.samples == nil {
+		r.samples = make(map[*metricDefinition]map[string]*metricSample)
+	}
+	samples := r.samples[metric]
+	if samples == nil {
+		samples = make(map[string]*metricSample)
+		r.samples[metric] = samples
+	}
+	key := strings.Join(labelValues, "\x00")
+	sample := samples[key]
+	if sample == nil {
+		sample = &metricSample{labelValues: labelValues}
+		samples[key] = sample
+	}
+	sample.value = f(sample.value)
+}
+
+// write renders metrics in the OpenMetrics text format.
+func (r *metricsRegistry) write() []byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var b bytes.Buffer
+	for _, metric := range metricDefinitions {
+		samples := r.samples[metric]
+		if len(samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "# TYPE %v %v\n", metric.name, metric.metricType)
+		fmt.Fprintf(&b, "# HELP %v %v\n", metric.name, metric.help)
+		keys := make([]string, 0, len(samples))
+		for key := range samples {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			sample := samples[key]
+			b.WriteString(metric.sampleName())
+			if len(metric.labels) > 0 {
+				labels := make([]string, 0, len(metric.labels))
+				for idx, label := range metric.labels {
+					labels = append(labels, fmt.Sprintf("%v=\"%v\"", label, escapeLabelValue(sample.labelValues[idx])))
+				}
+				fmt.Fprintf(&b, "{%v}", strings.Join(labels, ","))
+			}
+			fmt.Fprintf(&b, " %v\n", strconv.FormatFloat(sample.value, 'g', -1, 64))
+		}
+	}
+	b.WriteString("# EOF\n")
+	return b.Bytes()
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// recordRetry counts the retry of the operation for the error.
+func recordRetry(operation string, err error) {
+	submitMetrics.add(metricRetries, 1, operation, retryErrorCode(err))
+}
+
+// retryErrorCode returns the code of the error for metrics:
+// the HTTP status for errors of Google APIs, deadline_exceeded or unknown.
+func retryErrorCode(err error) string {
+	var apiError *googleapi.Error
+	if xerrors.As(err, &apiError) {
+		return strconv.Itoa(apiError.Code)
+	}
+	if xerrors.Is(err, context.DeadlineExceeded) {
+		return "deadline_exceeded"
+	}
+	return "unknown"
+}
+
+// recordBuildMetrics records metrics of the finished build.
+func recordBuildMetrics(name string, buildStatus string, createTime, startTime, finishTime string) {
+	if duration, ok := timeSpanDuration(createTime, startTime); ok {
+		submitMetrics.set(metricQueueDuration, duration.Seconds(), name)
+	}
+	if duration, ok := timeSpanDuration(startTime, finishTime); ok {
+		submitMetrics.set(metricBuildDuration, duration.Seconds(), name)
+	}
+	submitMetrics.set(metricBuildStatus, 1, name, buildStatus)
+}
+
+// exportMetrics writes metrics to MetricsFile and pushes them to Pushgateway if configured.
+// Failures are only logged not to change the result of the submission.
+func (c *Config) exportMetrics() {
+	if c.MetricsFile == "" && c.Pushgateway == "" {
+		return
+	}
+	submitMetrics.set(metricLastRun, float64(time.Now().Unix()))
+	body := submitMetrics.write()
+	if c.MetricsFile != "" {
+		if err := writeFileAtomically(c.MetricsFile, body); err != nil {
+			log.WithError(err).WithField("file", c.MetricsFile).Warning("Failed to write metrics")
+		} else {
+			log.WithField("file", c.MetricsFile).Debug("Wrote metrics")
+		}
+	}
+	if c.Pushgateway != "" {
+		if err := pushMetrics(c.Pushgateway, body); err != nil {
+			log.WithError(err).WithField("url", c.Pushgateway).Warning("Failed to push metrics")
+		} else {
+			log.WithField("url", c.Pushgateway).Debug("Pushed metrics")
+		}
+	}
+}
+
+// writeFileAtomically writes the file via a temporary file
+// not to let collectors read partially written files.
+func writeFileAtomically(file string, body []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// pushMetrics replaces metrics of the group in the Pushgateway.
+// /metrics/job/cloudbuild is appended to the URL if it has no path.
+func pushMetrics(pushgateway string, body []byte) error {
+	u, err := url.Parse(pushgateway)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return NewConfigError(fmt.Sprintf("Invalid Pushgateway URL '%v': must be a http or https URL", pushgateway), err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/metrics/job/cloudbuild"
+	}
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	client := &http.Client{Timeout: pushTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return xerrors.Errorf("Failed to push metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return xerrors.Errorf("Failed to push metrics: %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/api/googleapi"
+)
+
+func TestMetricsRegistryWrite(t *testing.T) {
+	registry := &metricsRegistry{}
+	registry.set(metricLastRun, 1609459200)
+	registry.set(metricArchiveSize, 1024)
+	registry.add(metricRetries, 1, "upload", "503")
+	registry.add(metricRetries, 1, "start build", "429")
+	registry.add(metricRetries, 1, "upload", "503")
+	registry.set(metricBuildStatus, 1, `api "v1"\path`+"\n", "SUCCESS")
+	registry.set(metricUploadDuration, 0.25)
+
+	expected := strings.Join([]string{
+		"# TYPE cloudbuild_source_archive_size_bytes gauge",
+		"# HELP cloudbuild_source_archive_size_bytes Size of the uploaded source archive.",
+		"cloudbuild_source_archive_size_bytes 1024",
+		"# TYPE cloudbuild_upload_duration_seconds gauge",
+		"# HELP cloudbuild_upload_duration_seconds Duration to upload the source archive.",
+		"cloudbuild_upload_duration_seconds 0.25",
+		"# TYPE cloudbuild_retries counter",
+		"# HELP cloudbuild_retries Number of retries of operations by the error code.",
+		`cloudbuild_retries_total{operation="start build",code="429"} 1`,
+		`cloudbuild_retries_total{operation="upload",code="503"} 2`,
+		"# TYPE cloudbuild_build_status gauge",
+		"# HELP cloudbuild_build_status Final status of the build with value 1.",
+		`cloudbuild_build_status{build="api \"v1\"\\path\n",status="SUCCESS"} 1`,
+		"# TYPE cloudbuild_last_run_timestamp_seconds gauge",
+		"# HELP cloudbuild_last_run_timestamp_seconds Time the submission finished.",
+		"cloudbuild_last_run_timestamp_seconds 1.6094592e+09",
+		"# EOF",
+		"",
+	}, "\n")
+	if actual := string(registry.write()); actual != expected {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, actual)
+	}
+}
+
+func TestMetricsRegistryWriteEmpty(t *testing.T) {
+	registry := &metricsRegistry{}
+	if actual := string(registry.write()); actual != "# EOF\n" {
+		t.Errorf("expected only # EOF, got %q", actual)
+	}
+}
+
+func TestRetryErrorCode(t *testing.T) {
+	testcases := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{
+			name:     "googleapi",
+			err:      &googleapi.Error{Code: http.StatusServiceUnavailable},
+			expected: "503",
+		},
+		{
+			name:     "wrapped googleapi",
+			err:      xerrors.Errorf("Failed to upload: %w", &googleapi.Error{Code: http.StatusTooManyRequests}),
+			expected: "429",
+		},
+		{
+			name:     "deadline",
+			err:      xerrors.Errorf("Failed to upload: %w", context.DeadlineExceeded),
+			expected: "deadline_exceeded",
+		},
+		{
+			name:     "unknown",
+			err:      xerrors.New("connection reset"),
+			expected: "unknown",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := retryErrorCode(tc.err); actual != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+// pushRecorder records the last request pushing metrics.
+type pushRecorder struct {
+	mutex       sync.Mutex
+	status      int
+	method      string
+	path        string
+	contentType string
+	body        string
+}
+
+func (r *pushRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.method = req.Method
+	r.path = req.URL.Path
+	r.contentType = req.Header.Get("Content-Type")
+	r.body = string(body)
+	if r.status != 0 {
+		w.WriteHeader(r.status)
+	}
+}
+
+func TestPushMetrics(t *testing.T) {
+	recorder := &pushRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	body := []byte("cloudbuild_source_archive_size_bytes 1024\n# EOF\n")
+	for _, testcase := range []struct {
+		url  string
+		path string
+	}{
+		{url: server.URL, path: "/metrics/job/cloudbuild"},
+		{url: server.URL + "/", path: "/metrics/job/cloudbuild"},
+		{url: server.URL + "/metrics/job/ci/instance/runner-1", path: "/metrics/job/ci/instance/runner-1"},
+	} {
+		if err := pushMetrics(testcase.url, body); err != nil {
+			t.Fatalf("%v: %v", testcase.url, err)
+		}
+		recorder.mutex.Lock()
+		if recorder.method != http.MethodPut {
+			t.Errorf("%v: expected PUT, got %v", testcase.url, recorder.method)
+		}
+		if recorder.path != testcase.path {
+			t.Errorf("%v: expected %v, got %v", testcase.url, testcase.path, recorder.path)
+		}
+		if !strings.HasPrefix(recorder.contentType, "application/openmetrics-text;") {
+			t.Errorf("%v: unexpected content type %v", testcase.url, recorder.contentType)
+		}
+		if recorder.body != string(body) {
+			t.Errorf("%v: unexpected body %q", testcase.url, recorder.body)
+		}
+		recorder.mutex.Unlock()
+	}
+}
+
+func TestPushMetricsError(t *testing.T) {
+	recorder := &pushRecorder{status: http.StatusBadRequest}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	if err := pushMetrics(server.URL, []byte("# EOF\n")); err == nil {
+		t.Error("expected an error for 400")
+	}
+}
+
+func TestPushMetricsInvalidURL(t *testing.T) {
+	for _, pushgateway := range []string{"localhost:9091", "ftp://localhost:9091", "http://"} {
+		err := pushMetrics(pushgateway, []byte("# EOF\n"))
+		var configError *ConfigError
+		if !xerrors.As(err, &configError) {
+			t.Errorf("%v: expected ConfigError, got %v", pushgateway, err)
+		}
+	}
+}
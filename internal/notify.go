@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/xerrors"
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+
+	"github.com/ikedam/cloudbuild/log"
+)
+
+const (
+	// NotifierTypeWebhook posts the result of builds in JSON.
+	NotifierTypeWebhook = "webhook"
+	// NotifierTypeSlack posts the message to Slack compatible incoming webhooks.
+	NotifierTypeSlack = "slack"
+	// NotifierTypeSMTP sends the message with emails.
+	NotifierTypeSMTP = "smtp"
+)
+
+// notifyTimeout is the timeout to send a notification.
+const notifyTimeout = 30 * time.Second
+
+// defaultNotifyTemplate is the template of messages used if not configured.
+const defaultNotifyTemplate = `Build {{.BuildID}} finished with {{.Status}}{{if .Duration}} in {{.Duration}}{{end}}
+{{- if .FailedStep}}
+Failed step: {{.FailedStep}}{{end}}
+{{- if .LogURL}}
+Logs: {{.LogURL}}{{end}}`
+
+// defaultNotifySubject is the template of subjects of emails used if not configured.
+const defaultNotifySubject = `[cloudbuild] Build {{.BuildID}} {{.Status}}`
+
+// NotifierConfig is the configuration of a destination to notify results of builds.
+type NotifierConfig struct {
+	// Type is the type of the notifier: webhook, slack or smtp.
+	Type string
+
+	// Statuses is the statuses of builds to notify. Notifies all statuses if empty.
+	Statuses []string
+
+	// Template is the text/template of the message.
+	Template string
+
+	// URL is the URL to post to for webhook and slack.
+	URL string
+
+	// Host is the SMTP server for smtp.
+	Host string
+
+	// Port is the port of the SMTP server for smtp. 25 if 0.
+	Port int
+
+	// Username is the user to authenticate to the SMTP server. No authentication if empty.
+	Username string
+
+	// Password is the password to authenticate to the SMTP server.
+	Password string
+
+	// From is the sender of emails for smtp.
+	From string
+
+	// To is the recipients of emails for smtp.
+	To []string
+
+	// Subject is the text/template of the subject of emails for smtp.
+	Subject string
+}
+
+// notification is the result of the build to notify.
+// This is also the data for templates.
+type notification struct {
+	BuildID     string   `json:"buildId"`
+	ProjectID   string   `json:"projectId"`
+	Status      string   `json:"status"`
+	Duration    string   `json:"duration,omitempty"`
+	FailedStep  string   `json:"failedStep,omitempty"`
+	FailedSteps []string `json:"failedSteps,omitempty"`
+	LogURL      string   `json:"logUrl,omitempty"`
+	Images      []string `json:"images,omitempty"`
+	Message     string   `json:"message"`
+}
+
+func newNotification(build *cloudbuild.Build) *notification {
+	n := &notification{
+		BuildID:   build.Id,
+		ProjectID: build.ProjectId,
+		Status:    build.Status,
+		LogURL:    build.LogUrl,
+		Images:    builtImages(build),
+	}
+	if duration, ok := timeSpanDuration(build.StartTime, build.FinishTime); ok {
+		n.Duration = duration.Round(time.Second).String()
+	}
+	for _, step := range failedSteps(build) {
+		n.FailedSteps = append(n.FailedSteps, step.String())
+	}
+	if len(n.FailedSteps) > 0 {
+		n.FailedStep = n.FailedSteps[0]
+	}
+	return n
+}
+
+// notify sends the result of the build to configured notifiers.
+// Failures are only logged not to change the result of the build.
+func (c *Config) notify(build *cloudbuild.Build) {
+	for idx, notifier := range c.Notifiers {
+		if !notifier.matchesStatus(build.Status) {
+			continue
+		}
+		if err := notifier.send(newNotification(build)); err != nil {
+			log.WithError(err).
+				WithField("notifier", idx).
+				WithField("type", notifier.Type).
+				Warning("Failed to notify the result of the build")
+			continue
+		}
+		log.WithField("notifier", idx).WithField("type", notifier.Type).Debug("Notified the result of the build")
+	}
+}
+
+func (n *NotifierConfig) matchesStatus(status string) bool {
+	if len(n.Statuses) == 0 {
+		return true
+	}
+	for _, s := range n.Statuses {
+		if strings.EqualFold(s, status) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *NotifierConfig) send(notification *notification) error {
+	var err error
+	if notification.Message, err = renderNotifyTemplate(n.Template, defaultNotifyTemplate, notification); err != nil {
+		return err
+	}
+	switch n.Type {
+	case NotifierTypeWebhook:
+		return postJSON(n.URL, notification)
+	case NotifierTypeSlack:
+		return postJSON(n.URL, map[string]string{"text": notification.Message})
+	case NotifierTypeSMTP:
+		return n.sendMail(notification)
+	}
+	return NewConfigError(fmt.Sprintf("Unknown notifier type '%v'", n.Type), nil)
+}
+
+func renderNotifyTemplate(text string, defaultText string, data interface{}) (string, error) {
+	if text == "" {
+		text = defaultText
+	}
+	tmpl, err := template.New("notification").Parse(text)
+	if err != nil {
+		return "", NewConfigError("Invalid template of the notification", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", NewConfigError("Failed to render the template of the notification", err)
+	}
+	return b.String(), nil
+}
+
+func postJSON(url string, payload interface{}) error {
+	if url == "" {
+		return NewConfigError("url is not configured", nil)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return xerrors.Errorf("Failed to serialize the notification: %w", err)
+	}
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return xerrors.Errorf("Failed to post the notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return xerrors.Errorf("Failed to post the notification: %v", resp.Status)
+	}
+	return nil
+}
+
+func (n *NotifierConfig) sendMail(notification *notification) error {
+	if n.Host == "" || n.From == "" || len(n.To) == 0 {
+		return NewConfigError("host, from and to are required for smtp", nil)
+	}
+	subject, err := renderNotifyTemplate(n.Subject, defaultNotifySubject, notification)
+	if err != nil {
+		return err
+	}
+	port := n.Port
+	if port == 0 {
+		port = 25
+	}
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %v\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %v\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %v\r\n", strings.ReplaceAll(subject, "\n", " "))
+	fmt.Fprint(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprint(&msg, "Content-Type: text/plain; charset=UTF-8\r\n")
+	fmt.Fprint(&msg, "\r\n")
+	fmt.Fprint(&msg, strings.ReplaceAll(notification.Message, "\n", "\r\n"))
+	fmt.Fprint(&msg, "\r\n")
+
+	addr := net.JoinHostPort(n.Host, strconv.Itoa(port))
+	if err := smtp.SendMail(addr, auth, n.From, n.To, msg.Bytes()); err != nil {
+		return xerrors.Errorf("Failed to send the email: %w", err)
+	}
+	return nil
+}
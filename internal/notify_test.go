@@ -0,0 +1,301 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+)
+
+func failedTestBuild() *cloudbuild.Build {
+	return &cloudbuild.Build{
+		Id:         "build-1",
+		ProjectId:  "my-project",
+		Status:     "FAILURE",
+		StartTime:  "2021-01-01T00:00:00Z",
+		FinishTime: "2021-01-01T00:01:30Z",
+		LogUrl:     "https://console.cloud.google.com/cloud-build/builds/build-1",
+		Steps: []*cloudbuild.BuildStep{
+			{Id: "compile", Name: "golang", Status: "SUCCESS"},
+			{Id: "test", Name: "golang", Status: "FAILURE"},
+		},
+	}
+}
+
+// httpRecorder records requests to the test server.
+type httpRecorder struct {
+	mutex  sync.Mutex
+	bodies [][]byte
+}
+
+func (r *httpRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.bodies = append(r.bodies, body)
+}
+
+func (r *httpRecorder) received() [][]byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.bodies
+}
+
+func TestNotifyWebhook(t *testing.T) {
+	recorder := &httpRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	config := &Config{
+		Notifiers: []*NotifierConfig{
+			{Type: NotifierTypeWebhook, URL: server.URL},
+		},
+	}
+	config.notify(failedTestBuild())
+
+	bodies := recorder.received()
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 request, got %v", len(bodies))
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(bodies[0], &payload); err != nil {
+		t.Fatalf("invalid JSON %q: %v", bodies[0], err)
+	}
+	expected := map[string]interface{}{
+		"buildId":    "build-1",
+		"projectId":  "my-project",
+		"status":     "FAILURE",
+		"duration":   "1m30s",
+		"failedStep": `Step #1 - "test" (golang): FAILURE`,
+		"logUrl":     "https://console.cloud.google.com/cloud-build/builds/build-1",
+	}
+	for key, value := range expected {
+		if payload[key] != value {
+			t.Errorf("%v: expected %q, got %q", key, value, payload[key])
+		}
+	}
+	if _, ok := payload["message"].(string); !ok {
+		t.Errorf("message is not set: %v", payload)
+	}
+}
+
+func TestNotifySlack(t *testing.T) {
+	recorder := &httpRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	config := &Config{
+		Notifiers: []*NotifierConfig{
+			{Type: NotifierTypeSlack, URL: server.URL},
+		},
+	}
+	config.notify(failedTestBuild())
+
+	bodies := recorder.received()
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 request, got %v", len(bodies))
+	}
+	var payload map[string]string
+	if err := json.Unmarshal(bodies[0], &payload); err != nil {
+		t.Fatalf("invalid JSON %q: %v", bodies[0], err)
+	}
+	expected := strings.Join([]string{
+		"Build build-1 finished with FAILURE in 1m30s",
+		`Failed step: Step #1 - "test" (golang): FAILURE`,
+		"Logs: https://console.cloud.google.com/cloud-build/builds/build-1",
+	}, "\n")
+	if payload["text"] != expected {
+		t.Errorf("expected %q, got %q", expected, payload["text"])
+	}
+}
+
+func TestNotifyCustomTemplate(t *testing.T) {
+	recorder := &httpRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	config := &Config{
+		Notifiers: []*NotifierConfig{
+			{
+				Type:     NotifierTypeSlack,
+				URL:      server.URL,
+				Template: "{{.Status}} {{.BuildID}} ({{.Duration}}) {{.FailedStep}} {{.LogURL}}",
+			},
+		},
+	}
+	config.notify(failedTestBuild())
+
+	bodies := recorder.received()
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 request, got %v", len(bodies))
+	}
+	var payload map[string]string
+	if err := json.Unmarshal(bodies[0], &payload); err != nil {
+		t.Fatalf("invalid JSON %q: %v", bodies[0], err)
+	}
+	expected := `FAILURE build-1 (1m30s) Step #1 - "test" (golang): FAILURE https://console.cloud.google.com/cloud-build/builds/build-1`
+	if payload["text"] != expected {
+		t.Errorf("expected %q, got %q", expected, payload["text"])
+	}
+}
+
+func TestNotifyStatuses(t *testing.T) {
+	recorder := &httpRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	config := &Config{
+		Notifiers: []*NotifierConfig{
+			{Type: NotifierTypeWebhook, URL: server.URL, Statuses: []string{"success"}},
+			{Type: NotifierTypeWebhook, URL: server.URL, Statuses: []string{"failure", "timeout"}},
+		},
+	}
+	config.notify(failedTestBuild())
+
+	bodies := recorder.received()
+	if len(bodies) != 1 {
+		t.Fatalf("expected only the notifier for failures to be called, got %v requests", len(bodies))
+	}
+}
+
+func TestNotifyWebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	notifier := &NotifierConfig{Type: NotifierTypeWebhook, URL: server.URL}
+	if err := notifier.send(newNotification(failedTestBuild())); err == nil {
+		t.Error("expected an error for the failed request")
+	}
+}
+
+// smtpStandIn is a minimal SMTP server accepting one message.
+type smtpStandIn struct {
+	listener net.Listener
+	done     chan struct{}
+	from     string
+	to       []string
+	data     string
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStandIn{listener: listener, done: make(chan struct{})}
+	go s.serve()
+	return s
+}
+
+func (s *smtpStandIn) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		fmt.Fprintf(conn, "%v\r\n", line)
+	}
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			s.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.to = append(s.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				dataLine, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			s.data = data.String()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestNotifySMTP(t *testing.T) {
+	server := newSMTPStandIn(t)
+	defer server.listener.Close()
+	host, port, err := net.SplitHostPort(server.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := &NotifierConfig{
+		Type: NotifierTypeSMTP,
+		Host: host,
+		Port: portNumber,
+		From: "cloudbuild@example.com",
+		To:   []string{"dev@example.com", "ops@example.com"},
+	}
+	if err := notifier.send(newNotification(failedTestBuild())); err != nil {
+		t.Fatal(err)
+	}
+	<-server.done
+
+	if server.from != "cloudbuild@example.com" {
+		t.Errorf("unexpected sender %q", server.from)
+	}
+	if strings.Join(server.to, ",") != "dev@example.com,ops@example.com" {
+		t.Errorf("unexpected recipients %v", server.to)
+	}
+	for _, expected := range []string{
+		"Subject: [cloudbuild] Build build-1 FAILURE\r\n",
+		"To: dev@example.com, ops@example.com\r\n",
+		"Build build-1 finished with FAILURE in 1m30s\r\n",
+		"Failed step: Step #1 - \"test\" (golang): FAILURE\r\n",
+		"Logs: https://console.cloud.google.com/cloud-build/builds/build-1\r\n",
+	} {
+		if !strings.Contains(server.data, expected) {
+			t.Errorf("expected %q in the message:\n%v", expected, server.data)
+		}
+	}
+}
+
+func TestNotifySMTPRequiresAddresses(t *testing.T) {
+	notifier := &NotifierConfig{Type: NotifierTypeSMTP, Host: "localhost"}
+	if err := notifier.send(newNotification(failedTestBuild())); err == nil {
+		t.Error("expected an error without from and to")
+	}
+}
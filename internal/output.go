@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// OutputFormatTable prints results in tables.
+	OutputFormatTable = "table"
+	// OutputFormatText prints results in human readable format.
+	OutputFormatText = "text"
+	// OutputFormatJSON prints results in JSON format.
+	OutputFormatJSON = "json"
+	// OutputFormatYAML prints results in YAML format.
+	OutputFormatYAML = "yaml"
+)
+
+// printJSON prints the value in JSON format.
+func printJSON(w io.Writer, v interface{}) error {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("Failed to serialize to JSON: %w", err)
+	}
+	if _, err := fmt.Fprintln(w, string(body)); err != nil {
+		return xerrors.Errorf("Failed to print: %w", err)
+	}
+	return nil
+}
+
+// printYAML prints the value in YAML format.
+// Field names follow JSON representations as types of Google APIs have only json tags.
+func printYAML(w io.Writer, v interface{}) error {
+	body, err := toYAML(v)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(body)); err != nil {
+		return xerrors.Errorf("Failed to print: %w", err)
+	}
+	return nil
+}
+
+// toYAML serializes the value in YAML format via JSON.
+func toYAML(v interface{}) ([]byte, error) {
+	jsonBody, err := json.Marshal(v)
+	if err != nil {
+		return nil, xerrors.Errorf("Failed to serialize to JSON: %w", err)
+	}
+	var m interface{}
+	if err := json.Unmarshal(jsonBody, &m); err != nil {
+		return nil, xerrors.Errorf("Failed to deserialize JSON: %w", err)
+	}
+	body, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, xerrors.Errorf("Failed to serialize to YAML: %w", err)
+	}
+	return body, nil
+}
+
+// formatBuildTime formats a timestamp returned from Cloud Build in the local time zone.
+func formatBuildTime(timestamp string) string {
+	if timestamp == "" {
+		return "-"
+	}
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return timestamp
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// formatDuration formats the duration between timestamps returned from Cloud Build.
+func formatDuration(startTime, endTime string) string {
+	duration, ok := timeSpanDuration(startTime, endTime)
+	if !ok {
+		return "-"
+	}
+	return duration.Round(time.Second).String()
+}
+
+// describeSource returns the short description of the source of the build.
+func describeSource(source *cloudbuild.Source) string {
+	if source == nil {
+		return "-"
+	}
+	if source.StorageSource != nil {
+		return (&GcsPath{
+			Bucket: source.StorageSource.Bucket,
+			Object: source.StorageSource.Object,
+		}).String()
+	}
+	if source.RepoSource != nil {
+		revision := source.RepoSource.CommitSha
+		if source.RepoSource.BranchName != "" {
+			revision = source.RepoSource.BranchName
+		} else if source.RepoSource.TagName != "" {
+			revision = source.RepoSource.TagName
+		}
+		return fmt.Sprintf("%v@%v", source.RepoSource.RepoName, revision)
+	}
+	if source.StorageSourceManifest != nil {
+		return (&GcsPath{
+			Bucket: source.StorageSourceManifest.Bucket,
+			Object: source.StorageSourceManifest.Object,
+		}).String()
+	}
+	return "-"
+}
+
+// formatList formats values for tables.
+func formatList(values []string) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	return strings.Join(values, ",")
+}
@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/xerrors"
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+)
+
+// buildReference matches references to results of other builds in substitutions:
+// $(NAME.buildId), $(NAME.digest), $(NAME.image), $(NAME.digest:IMAGE) and $(NAME.image:IMAGE).
+var buildReference = regexp.MustCompile(`\$\(([^.()\s]+)\.(buildId|digest|image)(?::([^()\s]+))?\)`)
+
+// validateDependencies checks dependencies of builds refer to existing builds without cycles,
+// and references in substitutions refer to dependencies.
+func (m *CloudBuildSubmitMany) validateDependencies() error {
+	specs := make(map[string]*BuildSpec, len(m.Builds))
+	for _, spec := range m.Builds {
+		specs[spec.Name] = spec
+	}
+	for _, spec := range m.Builds {
+		dependencies := make(map[string]bool, len(spec.DependsOn))
+		for _, dependency := range spec.DependsOn {
+			if _, ok := specs[dependency]; !ok {
+				return NewConfigError(
+					fmt.Sprintf("Build %v depends on unknown build %v", spec.Name, dependency),
+					nil,
+				)
+			}
+			dependencies[dependency] = true
+		}
+		for _, value := range spec.Substitutions {
+			for _, match := range buildReference.FindAllStringSubmatch(value, -1) {
+				if !dependencies[match[1]] {
+					return NewConfigError(
+						fmt.Sprintf("Build %v refers to %v not in dependsOn: %v", spec.Name, match[1], match[0]),
+						nil,
+					)
+				}
+			}
+		}
+	}
+
+	// detect cycles with depth first search
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := make(map[string]int, len(m.Builds))
+	var visit func(spec *BuildSpec, path []string) error
+	visit = func(spec *BuildSpec, path []string) error {
+		path = append(path, spec.Name)
+		switch states[spec.Name] {
+		case visiting:
+			return NewConfigError(
+				fmt.Sprintf("Dependencies of builds have a cycle: %v", strings.Join(path, " -> ")),
+				nil,
+			)
+		case visited:
+			return nil
+		}
+		states[spec.Name] = visiting
+		for _, dependency := range spec.DependsOn {
+			if err := visit(specs[dependency], path); err != nil {
+				return err
+			}
+		}
+		states[spec.Name] = visited
+		return nil
+	}
+	for _, spec := range m.Builds {
+		if err := visit(spec, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitDependencies waits for dependencies to finish.
+// Returns false if any of dependencies didn't succeed.
+// Skipped dependencies don't prevent the build from running.
+func (b *buildToStart) waitDependencies() bool {
+	succeeded := true
+	for _, dependency := range b.dependencies {
+		<-dependency.done
+		if dependency.notRun || dependency.err != nil {
+			succeeded = false
+		}
+	}
+	return succeeded
+}
+
+// resolveReferences replaces references to results of dependencies in substitutions.
+func (b *buildToStart) resolveReferences() error {
+	results := make(map[string]*buildToStart, len(b.dependencies))
+	for _, dependency := range b.dependencies {
+		results[dependency.spec.Name] = dependency
+	}
+	for key, value := range b.spec.Substitutions {
+		if !buildReference.MatchString(value) {
+			continue
+		}
+		var resolveErr error
+		resolved := buildReference.ReplaceAllStringFunc(value, func(reference string) string {
+			match := buildReference.FindStringSubmatch(reference)
+			resolvedValue, err := resolveReference(results[match[1]], match[2], match[3])
+			if err != nil && resolveErr == nil {
+				resolveErr = xerrors.Errorf("Failed to resolve %v in %v: %w", reference, key, err)
+			}
+			return resolvedValue
+		})
+		if resolveErr != nil {
+			return NewConfigError(fmt.Sprintf("Failed to resolve substitutions for %v", b.spec.Name), resolveErr)
+		}
+		if b.build.Substitutions == nil {
+			b.build.Substitutions = make(map[string]string)
+		}
+		b.build.Substitutions[key] = resolved
+	}
+	return nil
+}
+
+// resolveReference returns the value of the result of the dependency.
+func resolveReference(dependency *buildToStart, field string, imageName string) (string, error) {
+	if dependency.skipped != nil {
+		return "", xerrors.Errorf("Build %v is skipped", dependency.spec.Name)
+	}
+	result := dependency.submit.result
+	if field == "buildId" {
+		return result.Id, nil
+	}
+	image := findBuiltImage(result, imageName)
+	if image == nil {
+		if imageName == "" {
+			return "", xerrors.Errorf("Build %v built no images", dependency.spec.Name)
+		}
+		return "", xerrors.Errorf("Build %v didn't build %v", dependency.spec.Name, imageName)
+	}
+	if field == "digest" {
+		return image.Digest, nil
+	}
+	return fmt.Sprintf("%v@%v", imageNameWithoutTag(image.Name), image.Digest), nil
+}
+
+// findBuiltImage returns the image in the results of the build.
+// Returns the first image if name is empty. The name can be without the tag.
+func findBuiltImage(build *cloudbuild.Build, name string) *cloudbuild.BuiltImage {
+	if build.Results == nil {
+		return nil
+	}
+	for _, image := range build.Results.Images {
+		if name == "" || image.Name == name || imageNameWithoutTag(image.Name) == name {
+			return image
+		}
+	}
+	return nil
+}
+
+// imageNameWithoutTag removes the tag from the image name.
+func imageNameWithoutTag(name string) string {
+	slash := strings.LastIndex(name, "/")
+	if colon := strings.LastIndex(name, ":"); colon > slash {
+		return name[:colon]
+	}
+	return name
+}
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/xerrors"
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+
+	"github.com/ikedam/cloudbuild/log"
+)
+
+// CloudBuildRetry holds running state of retrying a build
+type CloudBuildRetry struct {
+	CloudBuildSubmit
+
+	// BuildID is the id of the build to retry.
+	BuildID string
+
+	// Recreate creates a new build from the definition of the build
+	// instead of using the retry API of Cloud Build.
+	// Builds are always recreated if substitutions are specified.
+	Recreate bool
+}
+
+// Execute retries the build and watches the new build
+func (r *CloudBuildRetry) Execute() error {
+	if err := r.Config.resolveProject(); err != nil {
+		return err
+	}
+	var err error
+	if r.ci, err = newCIIntegration(&r.Config); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	service, err := r.Config.newCloudBuildService(ctx)
+	if err != nil {
+		return err
+	}
+	buildService := cloudbuild.NewProjectsBuildsService(service)
+
+	var start func(ctx context.Context) (*cloudbuild.Operation, error)
+	if r.Recreate || len(r.Config.Substitutions) > 0 {
+		original, err := r.Config.getBuild(ctx, buildService.Get(r.Config.Project, r.BuildID), r.BuildID)
+		if err != nil {
+			return err
+		}
+		build, err := buildDefinition(original, r.Config.Substitutions)
+		if err != nil {
+			return NewConfigError("Invalid substitutions", err)
+		}
+		log.WithField("buildID", r.BuildID).
+			WithField("source", describeSource(build.Source)).
+			Info("Recreating build")
+		call := buildService.Create(r.Config.Project, build)
+		start = func(ctx context.Context) (*cloudbuild.Operation, error) {
+			return call.Context(ctx).Do()
+		}
+	} else {
+		log.WithField("buildID", r.BuildID).Info("Retrying build")
+		call := buildService.Retry(r.Config.Project, r.BuildID, &cloudbuild.RetryBuildRequest{
+			Id:        r.BuildID,
+			ProjectId: r.Config.Project,
+		})
+		start = func(ctx context.Context) (*cloudbuild.Operation, error) {
+			return call.Context(ctx).Do()
+		}
+	}
+
+	var operation *cloudbuild.Operation
+	if err := retryWithBackoff(r.Config.MaxStartBuildTryCount, "retry build", func() error {
+		startCtx, cancel := r.Config.cloudBuildContext(ctx)
+		defer cancel()
+		var err error
+		operation, err = start(startCtx)
+		return err
+	}); err != nil {
+		return NewServiceError(fmt.Sprintf("Failed to retry build %v", r.BuildID), err)
+	}
+	if r.buildID, err = buildIDFromOperation(operation); err != nil {
+		return NewServiceError(fmt.Sprintf("Failed to retry build %v", r.BuildID), err)
+	}
+	log.WithField("buildID", r.buildID).
+		WithField("originalBuildID", r.BuildID).
+		Info("Build queued")
+
+	return r.watchAndReport()
+}
+
+// buildDefinition returns the definition of the build to create a new build
+// with the same steps and the same source as the build.
+func buildDefinition(build *cloudbuild.Build, substitutions []string) (*cloudbuild.Build, error) {
+	definition := &cloudbuild.Build{
+		Steps:            build.Steps,
+		Source:           build.Source,
+		Timeout:          build.Timeout,
+		QueueTtl:         build.QueueTtl,
+		Images:           build.Images,
+		Artifacts:        build.Artifacts,
+		LogsBucket:       build.LogsBucket,
+		Options:          build.Options,
+		Tags:             build.Tags,
+		Secrets:          build.Secrets,
+		AvailableSecrets: build.AvailableSecrets,
+		ServiceAccount:   build.ServiceAccount,
+	}
+	// Built-in substitutions like BRANCH_NAME cannot be specified
+	// and they're provided from the source again.
+	for key, value := range build.Substitutions {
+		if !strings.HasPrefix(key, "_") {
+			continue
+		}
+		if definition.Substitutions == nil {
+			definition.Substitutions = make(map[string]string)
+		}
+		definition.Substitutions[key] = value
+	}
+	for _, step := range definition.Steps {
+		// Clear results of the previous build.
+		step.Status = ""
+		step.Timing = nil
+		step.PullTiming = nil
+	}
+	if err := applySubstitutions(definition, substitutions); err != nil {
+		return nil, xerrors.Errorf("Failed to apply substitutions: %w", err)
+	}
+	return definition, nil
+}
@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/xerrors"
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+
+	"github.com/ikedam/cloudbuild/log"
+)
+
+// secretVersionName matches resource names of versions of Secret Manager secrets.
+var secretVersionName = regexp.MustCompile(`^projects/[^/]+/secrets/[^/]+/versions/[^/]+$`)
+
+// secretName matches names of Secret Manager secrets.
+var secretName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseSecret parses NAME=VERSION expressions of secrets.
+// VERSION is projects/PROJECT/secrets/SECRET/versions/VERSION,
+// or the name of the secret in the project to use the latest version.
+func (c *Config) parseSecret(secret string) (*cloudbuild.SecretManagerSecret, error) {
+	keyValue := strings.SplitN(secret, "=", 2)
+	if len(keyValue) != 2 || keyValue[0] == "" {
+		return nil, xerrors.Errorf("Invalid secret '%v': must be NAME=VERSION", secret)
+	}
+	version := keyValue[1]
+	if secretName.MatchString(version) {
+		version = fmt.Sprintf("projects/%v/secrets/%v/versions/latest", c.Project, version)
+	}
+	if !secretVersionName.MatchString(version) {
+		return nil, xerrors.Errorf(
+			"Invalid secret '%v': must be projects/PROJECT/secrets/SECRET/versions/VERSION or a name of a secret",
+			secret,
+		)
+	}
+	return &cloudbuild.SecretManagerSecret{
+		Env:         keyValue[0],
+		VersionName: version,
+	}, nil
+}
+
+// applySecrets adds secrets configured with Secrets to availableSecrets of the build.
+// Secrets with the same name in cloudbuild.yaml are overridden.
+func (c *Config) applySecrets(build *cloudbuild.Build) error {
+	for _, secret := range c.Secrets {
+		s, err := c.parseSecret(secret)
+		if err != nil {
+			return err
+		}
+		if build.AvailableSecrets == nil {
+			build.AvailableSecrets = &cloudbuild.Secrets{}
+		}
+		replaced := false
+		for idx, declared := range build.AvailableSecrets.SecretManager {
+			if declared.Env == s.Env {
+				build.AvailableSecrets.SecretManager[idx] = s
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			build.AvailableSecrets.SecretManager = append(build.AvailableSecrets.SecretManager, s)
+		}
+	}
+	return nil
+}
+
+// validateSecrets checks secretEnv of every step is declared
+// in availableSecrets or secrets of the build.
+func validateSecrets(build *cloudbuild.Build) error {
+	declared := make(map[string]bool)
+	declare := func(env string) error {
+		if declared[env] {
+			return xerrors.Errorf("Secret %v is declared more than once", env)
+		}
+		declared[env] = true
+		return nil
+	}
+	if build.AvailableSecrets != nil {
+		for _, secret := range build.AvailableSecrets.SecretManager {
+			if !secretVersionName.MatchString(secret.VersionName) {
+				return xerrors.Errorf("Invalid versionName of secret %v: %v", secret.Env, secret.VersionName)
+			}
+			if err := declare(secret.Env); err != nil {
+				return err
+			}
+		}
+		for _, secret := range build.AvailableSecrets.Inline {
+			for env := range secret.EnvMap {
+				if err := declare(env); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, secret := range build.Secrets {
+		for env := range secret.SecretEnv {
+			if err := declare(env); err != nil {
+				return err
+			}
+		}
+	}
+
+	undeclared := []string{}
+	for idx, step := range build.Steps {
+		for _, env := range step.SecretEnv {
+			if !declared[env] {
+				undeclared = append(undeclared, fmt.Sprintf("%v (step %v)", env, stepName(idx, step)))
+			}
+		}
+	}
+	if len(undeclared) > 0 {
+		return xerrors.Errorf("secretEnv not declared in availableSecrets: %v", strings.Join(undeclared, ", "))
+	}
+	return nil
+}
+
+// stepName returns the name of the step to show in messages.
+func stepName(idx int, step *cloudbuild.BuildStep) string {
+	if step.Id != "" {
+		return fmt.Sprintf("#%v %v", idx, step.Id)
+	}
+	return fmt.Sprintf("#%v", idx)
+}
+
+// maskSensitiveSubstitutions registers values of sensitive substitutions
+// to be masked in logs and build logs.
+// Substitutions are sensitive if their keys are in SensitiveSubstitutions
+// or match sensitive key patterns of the log package.
+func (c *Config) maskSensitiveSubstitutions(substitutions map[string]string) {
+	for _, key := range c.SensitiveSubstitutions {
+		log.AddSensitiveKey(key)
+	}
+	for key, value := range substitutions {
+		if log.IsSensitiveKey(key) {
+			log.AddSecret(value)
+		}
+	}
+}
+
+// maskedSubstitutions returns substitutions to print with values of sensitive keys
+// and registered secrets masked.
+func maskedSubstitutions(substitutions map[string]string) map[string]string {
+	if substitutions == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(substitutions))
+	for key, value := range substitutions {
+		if log.IsSensitiveKey(key) {
+			masked[key] = log.MaskText
+		} else {
+			masked[key] = log.Mask(value)
+		}
+	}
+	return masked
+}
+
+// substitutionMap returns key=value expressions of substitutions as a map.
+// Invalid expressions are ignored.
+func substitutionMap(substitutions []string) map[string]string {
+	m := make(map[string]string, len(substitutions))
+	for _, substitution := range substitutions {
+		keyValue := strings.SplitN(substitution, "=", 2)
+		if len(keyValue) == 2 {
+			m[keyValue[0]] = keyValue[1]
+		}
+	}
+	return m
+}
+
+// maskingWriter masks secrets in written logs line by line
+// not to miss secrets split across writes.
+type maskingWriter struct {
+	out     io.Writer
+	partial []byte
+}
+
+func (w *maskingWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	idx := bytes.LastIndexByte(w.partial, '\n')
+	if idx < 0 {
+		return len(p), nil
+	}
+	lines := string(w.partial[:idx+1])
+	w.partial = append([]byte{}, w.partial[idx+1:]...)
+	if _, err := io.WriteString(w.out, log.Mask(lines)); err != nil {
+		return len(p), err
+	}
+	return len(p), nil
+}
+
+// Close flushes the last line not terminated with a line break.
+func (w *maskingWriter) Close() error {
+	if len(w.partial) == 0 {
+		return nil
+	}
+	line := string(w.partial)
+	w.partial = nil
+	_, err := io.WriteString(w.out, log.Mask(line))
+	return err
+}
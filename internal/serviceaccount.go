@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+	"google.golang.org/api/googleapi"
+	logging "google.golang.org/api/logging/v2"
+
+	"github.com/ikedam/cloudbuild/log"
+)
+
+const (
+	// loggingGcsOnly writes build logs only to Cloud Storage.
+	loggingGcsOnly = "GCS_ONLY"
+	// loggingLegacy writes build logs both to Cloud Logging and Cloud Storage.
+	loggingLegacy = "LEGACY"
+	// loggingCloudLoggingOnly writes build logs only to Cloud Logging.
+	loggingCloudLoggingOnly = "CLOUD_LOGGING_ONLY"
+	// loggingStackdriverOnly is the old name of loggingCloudLoggingOnly.
+	loggingStackdriverOnly = "STACKDRIVER_ONLY"
+	// loggingNone writes no build logs.
+	loggingNone = "NONE"
+)
+
+const (
+	// cloudLoggingPollingInterval is the minimum interval to read logs from Cloud Logging
+	// not to exceed the quota of reading logs (60 requests per minute per project).
+	cloudLoggingPollingInterval = 5 * time.Second
+	// cloudLoggingMaxPollingInterval is the maximum interval to back off for exceeding the quota.
+	cloudLoggingMaxPollingInterval = 60 * time.Second
+	// cloudLoggingIngestionDelay is the time to wait for the last logs of completed builds
+	// as Cloud Logging ingests logs with a delay.
+	cloudLoggingIngestionDelay = 5 * time.Second
+)
+
+// serviceAccountName returns the resource name of the service account
+// in the form Cloud Build accepts.
+func (c *Config) serviceAccountName() string {
+	if strings.HasPrefix(c.ServiceAccount, "projects/") {
+		return c.ServiceAccount
+	}
+	return fmt.Sprintf("projects/%v/serviceAccounts/%v", c.Project, c.ServiceAccount)
+}
+
+// applyServiceAccount sets the configured service account to the build,
+// and checks logging options are compatible with user-specified service accounts.
+// Cloud Build requires logsBucket, CLOUD_LOGGING_ONLY or NONE for them,
+// and logs are written to the directory for source archives if none of them are specified.
+func (s *CloudBuildSubmit) applyServiceAccount(build *cloudbuild.Build) error {
+	if s.Config.ServiceAccount != "" {
+		build.ServiceAccount = s.Config.serviceAccountName()
+	}
+	if build.ServiceAccount == "" {
+		return nil
+	}
+	mode := buildLogging(build)
+	switch mode {
+	case loggingCloudLoggingOnly, loggingStackdriverOnly, loggingNone:
+		return nil
+	case "", "LOGGING_UNSPECIFIED", loggingGcsOnly, loggingLegacy:
+	default:
+		return NewConfigError(fmt.Sprintf("Unknown logging option %v", mode), nil)
+	}
+	if build.LogsBucket != "" {
+		return nil
+	}
+	if mode == loggingLegacy {
+		return NewConfigError(
+			"logsBucket is required for LEGACY logging with a user-specified service account",
+			nil,
+		)
+	}
+	build.LogsBucket = fmt.Sprintf("gs://%v/logs", s.sourcePath.Bucket)
+	log.WithField("serviceAccount", build.ServiceAccount).
+		WithField("logsBucket", build.LogsBucket).
+		Info("Writing build logs to the bucket for source archives as the build runs as a service account")
+	return nil
+}
+
+// buildLogging returns the logging option of the build.
+func buildLogging(build *cloudbuild.Build) string {
+	if build.Options == nil {
+		return ""
+	}
+	return build.Options.Logging
+}
+
+// cloudLoggingLog reads build logs from Cloud Logging.
+type cloudLoggingLog struct {
+	entries *logging.EntriesService
+	project string
+	buildID string
+	// lastTimestamp is the timestamp of the last read entry.
+	lastTimestamp string
+	// readIDs is insertIds of entries read at lastTimestamp.
+	readIDs map[string]bool
+}
+
+// newCloudLoggingLog creates the reader of logs of the build in Cloud Logging.
+func (c *Config) newCloudLoggingLog(ctx context.Context, build *cloudbuild.Build) (*cloudLoggingLog, error) {
+	service, err := c.newLoggingService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	project := build.ProjectId
+	if project == "" {
+		project = c.Project
+	}
+	return &cloudLoggingLog{
+		entries: logging.NewEntriesService(service),
+		project: project,
+		buildID: build.Id,
+		readIDs: make(map[string]bool),
+	}, nil
+}
+
+// read writes entries added after the last read to w.
+// Returns the size of written logs.
+func (l *cloudLoggingLog) read(ctx context.Context, w io.Writer) (int64, error) {
+	filter := fmt.Sprintf(`resource.type="build" AND resource.labels.build_id="%v"`, l.buildID)
+	if l.lastTimestamp != "" {
+		filter = fmt.Sprintf(`%v AND timestamp>="%v"`, filter, l.lastTimestamp)
+	}
+	req := &logging.ListLogEntriesRequest{
+		ResourceNames: []string{fmt.Sprintf("projects/%v", l.project)},
+		Filter:        filter,
+		OrderBy:       "timestamp asc",
+		PageSize:      1000,
+	}
+	var written int64
+	for {
+		resp, err := l.entries.List(req).Context(ctx).Do()
+		if err != nil {
+			return written, err
+		}
+		for _, entry := range resp.Entries {
+			if entry.Timestamp == l.lastTimestamp && l.readIDs[entry.InsertId] {
+				continue
+			}
+			if entry.Timestamp != l.lastTimestamp {
+				l.lastTimestamp = entry.Timestamp
+				l.readIDs = make(map[string]bool)
+			}
+			l.readIDs[entry.InsertId] = true
+			n, err := io.WriteString(w, cloudLoggingLine(entry))
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+		if resp.NextPageToken == "" {
+			return written, nil
+		}
+		req.PageToken = resp.NextPageToken
+	}
+}
+
+// cloudLoggingLine formats the entry in the same way as logs in Cloud Storage.
+func cloudLoggingLine(entry *logging.LogEntry) string {
+	text := strings.TrimSuffix(entry.TextPayload, "\n")
+	if step := entry.Labels["build_step"]; step != "" && step != "MAIN" {
+		text = fmt.Sprintf("%v: %v", step, text)
+	}
+	return text + "\n"
+}
+
+// isQuotaExceededError tests the error is for exceeding the quota.
+func isQuotaExceededError(err error) bool {
+	var apiError *googleapi.Error
+	return xerrors.As(err, &apiError) && apiError.Code == http.StatusTooManyRequests
+}
@@ -0,0 +1,363 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/cast"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+)
+
+// commandSettingKeys is keys of settings used by the command not in Config.
+var commandSettingKeys = []string{
+	"logLevel",
+	"logFormat",
+	"logOutput",
+	"alwaysDump",
+	"profile",
+	"profiles",
+	"sensitiveKeyPatterns",
+}
+
+// ConfigSetting is a setting of Config.
+type ConfigSetting struct {
+	// Key is the key of the setting in settings files.
+	Key string
+	// Value is the value of the setting.
+	Value interface{}
+}
+
+// ConfigSettings returns settings of the configuration in the order of fields.
+func ConfigSettings(config *Config) []*ConfigSetting {
+	v := reflect.ValueOf(config).Elem()
+	t := v.Type()
+	settings := make([]*ConfigSetting, 0, t.NumField())
+	for idx := 0; idx < t.NumField(); idx++ {
+		key := t.Field(idx).Tag.Get("mapstructure")
+		if key == "" || key == "-" {
+			continue
+		}
+		settings = append(settings, &ConfigSetting{
+			Key:   key,
+			Value: v.Field(idx).Interface(),
+		})
+	}
+	return settings
+}
+
+// SettingKeys returns all keys available in settings files.
+func SettingKeys() []string {
+	keys := []string{}
+	for _, setting := range ConfigSettings(&Config{}) {
+		keys = append(keys, setting.Key)
+	}
+	return append(keys, commandSettingKeys...)
+}
+
+// ValidateSettings checks settings have no unknown keys and values have valid types.
+// Settings of profiles are validated in the same way.
+func ValidateSettings(settings map[string]interface{}) error {
+	problems := validateSettings("", settings)
+	if len(problems) == 0 {
+		return nil
+	}
+	return NewConfigError(
+		fmt.Sprintf("Invalid settings: %v", strings.Join(problems, "; ")),
+		nil,
+	)
+}
+
+func validateSettings(prefix string, settings map[string]interface{}) []string {
+	problems := []string{}
+	configKeys := make(map[string]bool)
+	for _, setting := range ConfigSettings(&Config{}) {
+		configKeys[strings.ToLower(setting.Key)] = true
+	}
+	configSettings := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		switch {
+		case strings.EqualFold(key, "logLevel"), strings.EqualFold(key, "logFormat"),
+			strings.EqualFold(key, "logOutput"), strings.EqualFold(key, "profile"):
+			if _, ok := value.(string); !ok {
+				problems = append(problems, fmt.Sprintf("%v%v must be a string", prefix, key))
+			}
+		case strings.EqualFold(key, "alwaysDump"):
+			if _, ok := value.(bool); !ok {
+				problems = append(problems, fmt.Sprintf("%v%v must be a boolean", prefix, key))
+			}
+		case strings.EqualFold(key, "sensitiveKeyPatterns"):
+			if _, err := cast.ToStringSliceE(value); err != nil {
+				problems = append(problems, fmt.Sprintf("%v%v must be a list of strings", prefix, key))
+			}
+		case strings.EqualFold(key, "profiles"):
+			if prefix != "" {
+				problems = append(problems, fmt.Sprintf("%v%v is not allowed in profiles", prefix, key))
+				continue
+			}
+			profiles, ok := toStringMap(value)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%v must be a mapping of profile names to settings", key))
+				continue
+			}
+			names := make([]string, 0, len(profiles))
+			for name := range profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				profile, ok := toStringMap(profiles[name])
+				if !ok {
+					problems = append(problems, fmt.Sprintf("profiles.%v must be a mapping of settings", name))
+					continue
+				}
+				problems = append(problems, validateSettings(fmt.Sprintf("profiles.%v.", name), profile)...)
+			}
+		case configKeys[strings.ToLower(key)]:
+			configSettings[key] = value
+		case deprecatedMsecSetting(key) != nil:
+			if _, err := cast.ToIntE(value); err != nil {
+				problems = append(problems, fmt.Sprintf("%v%v must be an integer", prefix, key))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("unknown key %v%v", prefix, key))
+		}
+	}
+
+	config := &Config{}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           config,
+		WeaklyTypedInput: true,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+	})
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	if err := decoder.Decode(configSettings); err != nil {
+		var decodeErr *mapstructure.Error
+		if xerrors.As(err, &decodeErr) {
+			for _, e := range decodeErr.Errors {
+				problems = append(problems, prefix+e)
+			}
+		} else {
+			problems = append(problems, prefix+err.Error())
+		}
+	} else {
+		values := make(map[string]interface{})
+		for _, setting := range ConfigSettings(config) {
+			values[strings.ToLower(setting.Key)] = setting.Value
+		}
+		for key := range configSettings {
+			for _, setting := range DurationSettings {
+				if !strings.EqualFold(key, setting.Key) {
+					continue
+				}
+				if err := setting.Check(values[strings.ToLower(setting.Key)].(time.Duration)); err != nil {
+					problems = append(problems, prefix+err.Error())
+				}
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// deprecatedMsecSetting returns the setting for the deprecated key in milliseconds.
+// Returns nil if the key isn't a deprecated one.
+func deprecatedMsecSetting(key string) *DurationSetting {
+	for _, setting := range DurationSettings {
+		if strings.EqualFold(key, setting.DeprecatedMsecKey) {
+			return setting
+		}
+	}
+	return nil
+}
+
+// toStringMap converts the decoded mapping to map[string]interface{}.
+func toStringMap(value interface{}) (map[string]interface{}, bool) {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			converted[fmt.Sprint(k)] = v
+		}
+		return converted, true
+	}
+	return nil, false
+}
+
+// SetSetting sets the value of the key in the YAML settings file.
+// The value is parsed as YAML. The key can be a dotted path like profiles.staging.project.
+// The file is created if not exists.
+func SetSetting(file string, key string, value string) error {
+	if err := validateSettingKey(key); err != nil {
+		return err
+	}
+	valueNode := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(value), valueNode); err != nil {
+		return NewConfigError(fmt.Sprintf("Invalid value '%v'", value), err)
+	}
+	if len(valueNode.Content) == 0 {
+		valueNode = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	} else {
+		valueNode = valueNode.Content[0]
+	}
+
+	return editSettingsFile(file, func(root *yaml.Node) error {
+		mapping := root
+		path := strings.Split(key, ".")
+		for _, name := range path[:len(path)-1] {
+			child := findMappingValue(mapping, name)
+			if child == nil {
+				child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				mapping.Content = append(
+					mapping.Content,
+					&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name},
+					child,
+				)
+			}
+			if child.Kind != yaml.MappingNode {
+				return NewConfigError(fmt.Sprintf("%v is not a mapping", name), nil)
+			}
+			mapping = child
+		}
+		name := path[len(path)-1]
+		for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+			if strings.EqualFold(mapping.Content[idx].Value, name) {
+				mapping.Content[idx+1] = valueNode
+				return nil
+			}
+		}
+		mapping.Content = append(
+			mapping.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name},
+			valueNode,
+		)
+		return nil
+	})
+}
+
+// UnsetSetting removes the key from the YAML settings file.
+// Returns false if the key isn't in the file.
+func UnsetSetting(file string, key string) (bool, error) {
+	removed := false
+	err := editSettingsFile(file, func(root *yaml.Node) error {
+		mapping := root
+		path := strings.Split(key, ".")
+		for _, name := range path[:len(path)-1] {
+			mapping = findMappingValue(mapping, name)
+			if mapping == nil || mapping.Kind != yaml.MappingNode {
+				return nil
+			}
+		}
+		name := path[len(path)-1]
+		for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+			if strings.EqualFold(mapping.Content[idx].Value, name) {
+				mapping.Content = append(mapping.Content[:idx], mapping.Content[idx+2:]...)
+				removed = true
+				return nil
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// validateSettingKey checks the key is available in settings files.
+func validateSettingKey(key string) error {
+	path := strings.Split(key, ".")
+	if strings.EqualFold(path[0], "profiles") {
+		if len(path) != 3 || path[1] == "" {
+			return NewConfigError(fmt.Sprintf("Specify keys of profiles like profiles.NAME.KEY: %v", key), nil)
+		}
+		path = path[2:]
+	}
+	if len(path) != 1 {
+		return NewConfigError(fmt.Sprintf("Unknown key %v", key), nil)
+	}
+	for _, known := range SettingKeys() {
+		if strings.EqualFold(known, path[0]) {
+			return nil
+		}
+	}
+	if setting := deprecatedMsecSetting(path[0]); setting != nil {
+		return NewConfigError(fmt.Sprintf("%v is deprecated. Use %v instead", path[0], setting.Key), nil)
+	}
+	return NewConfigError(fmt.Sprintf("Unknown key %v", key), nil)
+}
+
+// editSettingsFile edits the YAML settings file preserving comments,
+// and writes it back if the result is valid.
+func editSettingsFile(file string, edit func(root *yaml.Node) error) error {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+	default:
+		return NewConfigError(fmt.Sprintf("Only YAML settings files can be edited: %v", file), nil)
+	}
+	doc := &yaml.Node{}
+	body, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return NewConfigError(fmt.Sprintf("Failed to read %v", file), err)
+	}
+	if err := yaml.Unmarshal(body, doc); err != nil {
+		return NewConfigError(fmt.Sprintf("Failed to parse %v", file), err)
+	}
+	if len(doc.Content) == 0 {
+		doc = &yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return NewConfigError(fmt.Sprintf("%v is not a mapping", file), nil)
+	}
+	if err := edit(root); err != nil {
+		return err
+	}
+
+	settings := make(map[string]interface{})
+	if err := root.Decode(&settings); err != nil {
+		return NewConfigError("Failed to decode settings", err)
+	}
+	if err := ValidateSettings(settings); err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return xerrors.Errorf("Failed to serialize settings: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return xerrors.Errorf("Failed to serialize settings: %w", err)
+	}
+	if err := ioutil.WriteFile(file, b.Bytes(), 0644); err != nil {
+		return NewConfigError(fmt.Sprintf("Failed to write %v", file), err)
+	}
+	return nil
+}
+
+// findMappingValue returns the value of the key in the mapping node.
+func findMappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		if strings.EqualFold(mapping.Content[idx].Value, key) {
+			return mapping.Content[idx+1]
+		}
+	}
+	return nil
+}
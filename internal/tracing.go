@@ -0,0 +1,357 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+
+	"github.com/ikedam/cloudbuild/log"
+)
+
+// traceparentSubstitution is the substitution to pass the trace context to builds
+// in W3C traceparent format. Set only when the build refers to it
+// as Cloud Build rejects unused substitutions.
+const traceparentSubstitution = "_TRACEPARENT"
+
+// traceExportTimeout is the timeout to export spans.
+const traceExportTimeout = 30 * time.Second
+
+// tracer records spans of a submission in a trace,
+// and exports them in OTLP.
+// Methods of nil tracers and spans do nothing so that callers don't need to check tracing is enabled.
+type tracer struct {
+	traceID  [16]byte
+	exporter spanExporter
+
+	mutex sync.Mutex
+	// spans is finished spans not exported yet.
+	spans []*span
+}
+
+// span is an operation in a trace.
+type span struct {
+	tracer     *tracer
+	name       string
+	spanID     [8]byte
+	parentID   [8]byte
+	start      time.Time
+	end        time.Time
+	attributes map[string]interface{}
+	err        error
+}
+
+// spanExporter sends finished spans to somewhere.
+type spanExporter interface {
+	exportSpans(spans []*span) error
+}
+
+// newTracer creates the tracer exporting spans to TraceEndpoint.
+// Returns nil if tracing is not configured.
+func (c *Config) newTracer() (*tracer, error) {
+	if c.TraceEndpoint == "" {
+		return nil, nil
+	}
+	exporter, err := newOTLPExporter(c.TraceEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	t := &tracer{exporter: exporter}
+	if _, err := rand.Read(t.traceID[:]); err != nil {
+		return nil, xerrors.Errorf("Failed to generate trace id: %w", err)
+	}
+	log.WithField("traceID", hex.EncodeToString(t.traceID[:])).Debug("Tracing the submission")
+	return t, nil
+}
+
+// startSpan starts a span now. parent can be nil for the root span.
+func (t *tracer) startSpan(name string, parent *span) *span {
+	return t.newSpan(name, parent, time.Now())
+}
+
+func (t *tracer) newSpan(name string, parent *span, start time.Time) *span {
+	if t == nil {
+		return nil
+	}
+	s := &span{
+		tracer:     t,
+		name:       name,
+		start:      start,
+		attributes: make(map[string]interface{}),
+	}
+	if _, err := rand.Read(s.spanID[:]); err != nil {
+		log.WithError(err).Warning("Failed to generate span id")
+	}
+	if parent != nil {
+		s.parentID = parent.spanID
+	}
+	return s
+}
+
+// startChild starts a child span now.
+func (s *span) startChild(name string) *span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.startSpan(name, s)
+}
+
+// recordChild records a finished child span with timestamps returned from Cloud Build.
+// Does nothing if timestamps are not available.
+func (s *span) recordChild(name string, startTime, endTime string, attributes map[string]interface{}) *span {
+	if s == nil {
+		return nil
+	}
+	start, err := time.Parse(time.RFC3339Nano, startTime)
+	if err != nil {
+		return nil
+	}
+	end, err := time.Parse(time.RFC3339Nano, endTime)
+	if err != nil {
+		return nil
+	}
+	child := s.tracer.newSpan(name, s, start)
+	for key, value := range attributes {
+		child.setAttribute(key, value)
+	}
+	child.finishAt(end, nil)
+	return child
+}
+
+// setAttribute sets the attribute of the span.
+func (s *span) setAttribute(key string, value interface{}) *span {
+	if s == nil {
+		return nil
+	}
+	s.attributes[key] = value
+	return s
+}
+
+// finish ends the span now. err is recorded as the status of the span.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.finishAt(time.Now(), err)
+}
+
+func (s *span) finishAt(end time.Time, err error) {
+	s.end = end
+	s.err = err
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	s.tracer.spans = append(s.tracer.spans, s)
+}
+
+// traceparent returns the trace context of the span in W3C traceparent format.
+func (s *span) traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf(
+		"00-%v-%v-01",
+		hex.EncodeToString(s.tracer.traceID[:]),
+		hex.EncodeToString(s.spanID[:]),
+	)
+}
+
+// flush exports finished spans.
+// Failures are only logged not to change the result of the submission.
+func (t *tracer) flush() {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mutex.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	if err := t.exporter.exportSpans(spans); err != nil {
+		log.WithError(err).WithField("count", len(spans)).Warning("Failed to export spans")
+		return
+	}
+	log.WithField("count", len(spans)).Debug("Exported spans")
+}
+
+// setTraceparent passes the trace context of the span to the build
+// if the build refers to the substitution.
+func setTraceparent(build *cloudbuild.Build, s *span) {
+	if s == nil {
+		return
+	}
+	body, err := json.Marshal(build.Steps)
+	if err != nil || !bytes.Contains(body, []byte(traceparentSubstitution)) {
+		return
+	}
+	if build.Substitutions == nil {
+		build.Substitutions = make(map[string]string)
+	}
+	build.Substitutions[traceparentSubstitution] = s.traceparent()
+}
+
+// recordBuildSpans records spans of the queue wait, the build and its steps
+// from timestamps of the finished build.
+func recordBuildSpans(parent *span, build *cloudbuild.Build) {
+	if parent == nil {
+		return
+	}
+	parent.recordChild("queue", build.CreateTime, build.StartTime, map[string]interface{}{
+		"buildID": build.Id,
+	})
+	buildSpan := parent.recordChild("build", build.StartTime, build.FinishTime, map[string]interface{}{
+		"buildID": build.Id,
+		"status":  build.Status,
+	})
+	if buildSpan == nil {
+		return
+	}
+	for idx, step := range build.Steps {
+		if step.Timing == nil {
+			continue
+		}
+		buildSpan.recordChild(
+			fmt.Sprintf("step %v", stepName(idx, step)),
+			step.Timing.StartTime,
+			step.Timing.EndTime,
+			map[string]interface{}{
+				"image":  step.Name,
+				"status": step.Status,
+			},
+		)
+	}
+}
+
+// otlpExporter exports spans with OTLP over HTTP in JSON encoding.
+type otlpExporter struct {
+	endpoint string
+}
+
+// newOTLPExporter creates the exporter for the endpoint like http://localhost:4318.
+// /v1/traces is appended if the endpoint has no path.
+func newOTLPExporter(endpoint string) (*otlpExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, NewConfigError(fmt.Sprintf("Invalid trace endpoint '%v': must be a http or https URL", endpoint), err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return &otlpExporter{endpoint: u.String()}, nil
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []*otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusCodeError  = 2
+)
+
+func (e *otlpExporter) exportSpans(spans []*span) error {
+	otlpSpans := make([]*otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		o := &otlpSpan{
+			TraceID:           hex.EncodeToString(s.tracer.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.attributes),
+		}
+		if s.parentID != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != nil {
+			o.Status = &otlpStatus{
+				Code:    otlpStatusCodeError,
+				Message: log.Mask(s.err.Error()),
+			}
+		}
+		otlpSpans = append(otlpSpans, o)
+	}
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]interface{}{
+						"service.name": "cloudbuild",
+					}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{
+							"name": "github.com/ikedam/cloudbuild",
+						},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return xerrors.Errorf("Failed to serialize spans: %w", err)
+	}
+	client := &http.Client{Timeout: traceExportTimeout}
+	resp, err := client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return xerrors.Errorf("Failed to export spans: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return xerrors.Errorf("Failed to export spans: %v", resp.Status)
+	}
+	return nil
+}
+
+func otlpAttributes(attributes map[string]interface{}) []*otlpKeyValue {
+	keyValues := make([]*otlpKeyValue, 0, len(attributes))
+	for key, value := range attributes {
+		var v map[string]interface{}
+		switch typed := value.(type) {
+		case bool:
+			v = map[string]interface{}{"boolValue": typed}
+		case int:
+			v = map[string]interface{}{"intValue": strconv.Itoa(typed)}
+		case int64:
+			v = map[string]interface{}{"intValue": strconv.FormatInt(typed, 10)}
+		case float64:
+			v = map[string]interface{}{"doubleValue": typed}
+		default:
+			v = map[string]interface{}{"stringValue": log.Mask(fmt.Sprint(value))}
+		}
+		keyValues = append(keyValues, &otlpKeyValue{Key: key, Value: v})
+	}
+	return keyValues
+}
@@ -0,0 +1,513 @@
+package internal
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+	"google.golang.org/api/option"
+)
+
+// memoryExporter keeps exported spans in memory.
+type memoryExporter struct {
+	mutex sync.Mutex
+	spans []*span
+}
+
+func (e *memoryExporter) exportSpans(spans []*span) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memoryExporter) exported() []*span {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.spans
+}
+
+func newMemoryTracer() (*tracer, *memoryExporter) {
+	exporter := &memoryExporter{}
+	t := &tracer{exporter: exporter}
+	copy(t.traceID[:], []byte("0123456789abcdef"))
+	return t, exporter
+}
+
+// spansNamed returns spans with the name in the order of finishing.
+func spansNamed(spans []*span, name string) []*span {
+	found := []*span{}
+	for _, s := range spans {
+		if s.name == name {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+// fakeGoogleCloud serves Cloud Storage uploads and Cloud Build creations
+// failing the first request of each with retryable errors.
+type fakeGoogleCloud struct {
+	mutex   sync.Mutex
+	uploads int
+	creates int
+}
+
+func (f *fakeGoogleCloud) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ioutil.ReadAll(req.Body)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasPrefix(req.URL.Path, "/upload/storage/v1/b/bucket/o"):
+		f.uploads++
+		if f.uploads == 1 {
+			// Cloud Storage client retries 5xx by itself.
+			w.Write([]byte(`broken`))
+			return
+		}
+		w.Write([]byte(`{"bucket":"bucket","name":"source/archive.tgz"}`))
+	case req.URL.Path == "/v1/projects/my-project/builds":
+		f.creates++
+		if f.creates == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":{"code":503,"message":"unavailable"}}`))
+			return
+		}
+		w.Write([]byte(`{
+			"name": "operations/build-1",
+			"metadata": {
+				"@type": "type.googleapis.com/google.devtools.cloudbuild.v1.BuildOperationMetadata",
+				"build": {"id": "build-1"}
+			}
+		}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"code":404,"message":"not found"}}`))
+	}
+}
+
+func TestTracingSubmitSpans(t *testing.T) {
+	fake := &fakeGoogleCloud{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sourceDir := filepath.Join(dir, "source")
+	if err := os.Mkdir(sourceDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(sourceDir, "cloudbuild.yaml"), []byte("steps: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, exporter := newMemoryTracer()
+	s := &CloudBuildSubmit{
+		Config: Config{
+			SourceDir:             sourceDir,
+			IgnoreFile:            ".gcloudignore",
+			Project:               "my-project",
+			GcsSourceStagingDir:   "gs://bucket/source",
+			AccessTokenFile:       tokenFile,
+			MaxUploadTryCount:     3,
+			MaxStartBuildTryCount: 3,
+			extraClientOptions:    []option.ClientOption{option.WithEndpoint(server.URL + "/")},
+		},
+		tracer: tr,
+	}
+	s.span = tr.startSpan("submit", nil)
+	if err := s.prepareSourcePath(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.uploadSource(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.startBuild(&cloudbuild.Build{}); err != nil {
+		t.Fatal(err)
+	}
+	s.span.finish(nil)
+	tr.flush()
+
+	spans := exporter.exported()
+	roots := spansNamed(spans, "submit")
+	if len(roots) != 1 {
+		t.Fatalf("expected 1 submit span, got %v", len(roots))
+	}
+	root := roots[0]
+	if root.parentID != [8]byte{} {
+		t.Errorf("submit span has a parent %x", root.parentID)
+	}
+	for _, expected := range []struct {
+		name     string
+		attempts int
+		failures int
+	}{
+		{name: "createSourceArchive", attempts: 2, failures: 0},
+		{name: "uploadCloudStorage", attempts: 2, failures: 1},
+		{name: "runCloudBuild", attempts: 2, failures: 1},
+	} {
+		children := spansNamed(spans, expected.name)
+		if len(children) != expected.attempts {
+			t.Errorf("expected %v %v spans, got %v", expected.attempts, expected.name, len(children))
+			continue
+		}
+		failures := 0
+		for idx, child := range children {
+			if child.parentID != root.spanID {
+				t.Errorf("%v: expected the parent %x, got %x", expected.name, root.spanID, child.parentID)
+			}
+			if child.tracer.traceID != root.tracer.traceID {
+				t.Errorf("%v: different trace", expected.name)
+			}
+			if child.spanID == root.spanID {
+				t.Errorf("%v: same span id as the parent", expected.name)
+			}
+			if child.attributes["attempt"] != idx+1 {
+				t.Errorf("%v: expected attempt %v, got %v", expected.name, idx+1, child.attributes["attempt"])
+			}
+			if child.err != nil {
+				failures++
+			}
+		}
+		if failures != expected.failures {
+			t.Errorf("expected %v failed %v spans, got %v", expected.failures, expected.name, failures)
+		}
+	}
+	runs := spansNamed(spans, "runCloudBuild")
+	if len(runs) == 2 && runs[1].attributes["buildID"] != "build-1" {
+		t.Errorf("expected buildID of runCloudBuild, got %v", runs[1].attributes["buildID"])
+	}
+}
+
+func TestTracingReadLogSpans(t *testing.T) {
+	var mutex sync.Mutex
+	lists := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if req.URL.Path != "/v2/entries:list" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"message":"not found"}}`))
+			return
+		}
+		lists++
+		if lists == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":{"code":429,"message":"quota exceeded"}}`))
+			return
+		}
+		w.Write([]byte(`{"entries": [
+			{"insertId": "1", "timestamp": "2021-01-01T00:00:01Z", "textPayload": "hello"},
+			{"insertId": "2", "timestamp": "2021-01-01T00:00:02Z", "textPayload": "world"}
+		]}`))
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{
+		Project:            "my-project",
+		AccessTokenFile:    tokenFile,
+		MaxReadLogTryCount: 3,
+		extraClientOptions: []option.ClientOption{option.WithEndpoint(server.URL + "/")},
+	}
+	build := &cloudbuild.Build{Id: "build-1", ProjectId: "my-project"}
+	loggingLog, err := config.newCloudLoggingLog(context.Background(), build)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr, exporter := newMemoryTracer()
+	watchSpan := tr.startSpan("watchCloudBuild", nil)
+	var logs strings.Builder
+	w := &watchLogStatus{
+		config:     config,
+		ctx:        context.Background(),
+		build:      build,
+		loggingLog: loggingLog,
+		logWriter:  &logs,
+		span:       watchSpan,
+	}
+	for i := 0; i < 2; i++ {
+		w.loggingNextRead = time.Time{}
+		if err := w.readCloudLoggingLog(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	watchSpan.finish(nil)
+	tr.flush()
+
+	if logs.String() != "hello\nworld\n" {
+		t.Errorf("unexpected logs %q", logs.String())
+	}
+	reads := spansNamed(exporter.exported(), "readLog")
+	if len(reads) != 2 {
+		t.Fatalf("expected 2 readLog spans, got %v", len(reads))
+	}
+	for idx, expected := range []struct {
+		attempt int
+		size    int64
+		failed  bool
+	}{
+		{attempt: 1, size: 0, failed: true},
+		{attempt: 2, size: 12, failed: false},
+	} {
+		read := reads[idx]
+		if read.parentID != watchSpan.spanID {
+			t.Errorf("readLog #%v: expected the parent watchCloudBuild span", idx)
+		}
+		if read.attributes["source"] != "cloudLogging" {
+			t.Errorf("readLog #%v: unexpected source %v", idx, read.attributes["source"])
+		}
+		if read.attributes["attempt"] != expected.attempt {
+			t.Errorf("readLog #%v: expected attempt %v, got %v", idx, expected.attempt, read.attributes["attempt"])
+		}
+		if read.attributes["offset"] != int64(0) {
+			t.Errorf("readLog #%v: expected offset 0, got %v", idx, read.attributes["offset"])
+		}
+		if read.attributes["size"] != expected.size {
+			t.Errorf("readLog #%v: expected size %v, got %v", idx, expected.size, read.attributes["size"])
+		}
+		if (read.err != nil) != expected.failed {
+			t.Errorf("readLog #%v: unexpected error %v", idx, read.err)
+		}
+	}
+}
+
+func TestRecordBuildSpans(t *testing.T) {
+	tr, exporter := newMemoryTracer()
+	parent := tr.startSpan("submit", nil)
+	recordBuildSpans(parent, &cloudbuild.Build{
+		Id:         "build-1",
+		Status:     "FAILURE",
+		CreateTime: "2021-01-01T00:00:00Z",
+		StartTime:  "2021-01-01T00:00:10Z",
+		FinishTime: "2021-01-01T00:01:00Z",
+		Steps: []*cloudbuild.BuildStep{
+			{
+				Id:     "compile",
+				Name:   "golang",
+				Status: "SUCCESS",
+				Timing: &cloudbuild.TimeSpan{
+					StartTime: "2021-01-01T00:00:11Z",
+					EndTime:   "2021-01-01T00:00:30Z",
+				},
+			},
+			{
+				Name:   "golang",
+				Status: "FAILURE",
+				Timing: &cloudbuild.TimeSpan{
+					StartTime: "2021-01-01T00:00:30Z",
+					EndTime:   "2021-01-01T00:00:59Z",
+				},
+			},
+			{
+				// not run
+				Name:   "alpine",
+				Status: "QUEUED",
+			},
+		},
+	})
+	parent.finish(nil)
+	tr.flush()
+	spans := exporter.exported()
+
+	queue := spansNamed(spans, "queue")
+	if len(queue) != 1 {
+		t.Fatalf("expected 1 queue span, got %v", len(queue))
+	}
+	if queue[0].parentID != parent.spanID {
+		t.Errorf("queue: unexpected parent %x", queue[0].parentID)
+	}
+	if d := queue[0].end.Sub(queue[0].start).Seconds(); d != 10 {
+		t.Errorf("queue: expected 10s, got %vs", d)
+	}
+
+	build := spansNamed(spans, "build")
+	if len(build) != 1 {
+		t.Fatalf("expected 1 build span, got %v", len(build))
+	}
+	if build[0].parentID != parent.spanID {
+		t.Errorf("build: unexpected parent %x", build[0].parentID)
+	}
+	if d := build[0].end.Sub(build[0].start).Seconds(); d != 50 {
+		t.Errorf("build: expected 50s, got %vs", d)
+	}
+	if build[0].attributes["status"] != "FAILURE" {
+		t.Errorf("build: unexpected status %v", build[0].attributes["status"])
+	}
+
+	for _, expected := range []struct {
+		name    string
+		seconds float64
+		status  string
+	}{
+		{name: "step #0 compile", seconds: 19, status: "SUCCESS"},
+		{name: "step #1", seconds: 29, status: "FAILURE"},
+	} {
+		steps := spansNamed(spans, expected.name)
+		if len(steps) != 1 {
+			t.Errorf("expected 1 %v span, got %v", expected.name, len(steps))
+			continue
+		}
+		step := steps[0]
+		if step.parentID != build[0].spanID {
+			t.Errorf("%v: expected the parent build span", expected.name)
+		}
+		if d := step.end.Sub(step.start).Seconds(); d != expected.seconds {
+			t.Errorf("%v: expected %vs, got %vs", expected.name, expected.seconds, d)
+		}
+		if step.attributes["status"] != expected.status {
+			t.Errorf("%v: unexpected status %v", expected.name, step.attributes["status"])
+		}
+	}
+	// submit, queue, build and 2 steps with timings.
+	if len(spans) != 5 {
+		t.Errorf("expected 5 spans, got %v", len(spans))
+	}
+}
+
+func TestSetTraceparent(t *testing.T) {
+	tr, _ := newMemoryTracer()
+	s := tr.startSpan("submit", nil)
+
+	unused := &cloudbuild.Build{
+		Steps: []*cloudbuild.BuildStep{
+			{Name: "alpine", Args: []string{"echo", "hello"}},
+		},
+	}
+	setTraceparent(unused, s)
+	if _, ok := unused.Substitutions[traceparentSubstitution]; ok {
+		t.Errorf("_TRACEPARENT is set for the build not referring it")
+	}
+
+	used := &cloudbuild.Build{
+		Steps: []*cloudbuild.BuildStep{
+			{Name: "alpine", Env: []string{"TRACEPARENT=$_TRACEPARENT"}},
+		},
+	}
+	setTraceparent(used, s)
+	expected := "00-" + hex.EncodeToString(tr.traceID[:]) + "-" + hex.EncodeToString(s.spanID[:]) + "-01"
+	if used.Substitutions[traceparentSubstitution] != expected {
+		t.Errorf("expected %v, got %v", expected, used.Substitutions[traceparentSubstitution])
+	}
+
+	disabled := &cloudbuild.Build{
+		Steps: []*cloudbuild.BuildStep{
+			{Name: "alpine", Env: []string{"TRACEPARENT=$_TRACEPARENT"}},
+		},
+	}
+	setTraceparent(disabled, nil)
+	if _, ok := disabled.Substitutions[traceparentSubstitution]; ok {
+		t.Errorf("_TRACEPARENT is set without tracing")
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var mutex sync.Mutex
+	var path, contentType string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		path = req.URL.Path
+		contentType = req.Header.Get("Content-Type")
+		body, _ = ioutil.ReadAll(req.Body)
+	}))
+	defer server.Close()
+
+	exporter, err := newOTLPExporter(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &tracer{exporter: exporter}
+	copy(tr.traceID[:], []byte("0123456789abcdef"))
+	root := tr.startSpan("submit", nil).setAttribute("config", "cloudbuild.yaml")
+	child := root.startChild("runCloudBuild").setAttribute("attempt", 1)
+	child.finish(NewServiceError("Failed to start", nil))
+	root.finish(nil)
+	tr.flush()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if path != "/v1/traces" {
+		t.Errorf("unexpected path %v", path)
+	}
+	if contentType != "application/json" {
+		t.Errorf("unexpected content type %v", contentType)
+	}
+	var payload struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpKeyValue `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Scope struct {
+					Name string `json:"name"`
+				} `json:"scope"`
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("invalid JSON %q: %v", body, err)
+	}
+	if len(payload.ResourceSpans) != 1 || len(payload.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected structure: %s", body)
+	}
+	resource := payload.ResourceSpans[0].Resource
+	if len(resource.Attributes) != 1 ||
+		resource.Attributes[0].Key != "service.name" ||
+		resource.Attributes[0].Value["stringValue"] != "cloudbuild" {
+		t.Errorf("unexpected resource: %s", body)
+	}
+	scope := payload.ResourceSpans[0].ScopeSpans[0]
+	if scope.Scope.Name != "github.com/ikedam/cloudbuild" {
+		t.Errorf("unexpected scope %v", scope.Scope.Name)
+	}
+	if len(scope.Spans) != 2 {
+		t.Fatalf("expected 2 spans, got %v", len(scope.Spans))
+	}
+	exportedChild, exportedRoot := scope.Spans[0], scope.Spans[1]
+	traceID := hex.EncodeToString(tr.traceID[:])
+	if exportedRoot.Name != "submit" || exportedRoot.TraceID != traceID || exportedRoot.ParentSpanID != "" {
+		t.Errorf("unexpected root span: %+v", exportedRoot)
+	}
+	if exportedRoot.Status != nil {
+		t.Errorf("unexpected status of the root span: %+v", exportedRoot.Status)
+	}
+	if exportedChild.Name != "runCloudBuild" ||
+		exportedChild.TraceID != traceID ||
+		exportedChild.ParentSpanID != exportedRoot.SpanID {
+		t.Errorf("unexpected child span: %+v", exportedChild)
+	}
+	if exportedChild.Status == nil || exportedChild.Status.Code != otlpStatusCodeError {
+		t.Errorf("expected the error status: %+v", exportedChild.Status)
+	}
+	if len(exportedChild.Attributes) != 1 ||
+		exportedChild.Attributes[0].Key != "attempt" ||
+		exportedChild.Attributes[0].Value["intValue"] != "1" {
+		t.Errorf("unexpected attributes: %s", body)
+	}
+	if exportedChild.StartTimeUnixNano == "" || exportedChild.EndTimeUnixNano == "" {
+		t.Errorf("no timestamps: %+v", exportedChild)
+	}
+}
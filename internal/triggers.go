@@ -0,0 +1,570 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/xerrors"
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+	"google.golang.org/api/googleapi"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ikedam/cloudbuild/log"
+)
+
+// triggerOutputOnlyFields is fields of triggers set by Cloud Build.
+// They're excluded from exported definitions and differences to apply.
+// Nested fields are separated with dots.
+var triggerOutputOnlyFields = []string{
+	"id",
+	"createTime",
+	"resourceName",
+	"pubsubConfig.state",
+	"pubsubConfig.subscription",
+	"webhookConfig.state",
+}
+
+// newTriggerService creates the client for triggers of Cloud Build.
+func (c *Config) newTriggerService(ctx context.Context) (*cloudbuild.ProjectsTriggersService, error) {
+	service, err := c.newCloudBuildService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return cloudbuild.NewProjectsTriggersService(service), nil
+}
+
+// listTriggers lists all triggers in the project.
+func (c *Config) listTriggers(ctx context.Context, triggerService *cloudbuild.ProjectsTriggersService) ([]*cloudbuild.BuildTrigger, error) {
+	triggers := []*cloudbuild.BuildTrigger{}
+	pageToken := ""
+	for {
+		call := triggerService.List(c.Project).PageToken(pageToken).PageSize(listPageSize)
+		var response *cloudbuild.ListBuildTriggersResponse
+		if err := retryWithBackoff(c.MaxGetBuildTryCount, "list triggers", func() error {
+			listCtx, cancel := c.cloudBuildContext(ctx)
+			defer cancel()
+			var err error
+			response, err = call.Context(listCtx).Do()
+			return err
+		}); err != nil {
+			return nil, NewServiceError("Failed to list triggers", err)
+		}
+		triggers = append(triggers, response.Triggers...)
+		if response.NextPageToken == "" {
+			break
+		}
+		pageToken = response.NextPageToken
+	}
+	return triggers, nil
+}
+
+// getTrigger gets the trigger by the id or the name.
+// Returns nil without errors if the trigger doesn't exist.
+func (c *Config) getTrigger(
+	ctx context.Context,
+	triggerService *cloudbuild.ProjectsTriggersService,
+	trigger string,
+) (*cloudbuild.BuildTrigger, error) {
+	call := triggerService.Get(c.Project, trigger)
+	var result *cloudbuild.BuildTrigger
+	if err := retryWithBackoff(c.MaxGetBuildTryCount, "get trigger", func() error {
+		getCtx, cancel := c.cloudBuildContext(ctx)
+		defer cancel()
+		var err error
+		result, err = call.Context(getCtx).Do()
+		return err
+	}); err != nil {
+		var apiError *googleapi.Error
+		if xerrors.As(err, &apiError) && apiError.Code == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, NewServiceError(fmt.Sprintf("Failed to get trigger %v", trigger), err)
+	}
+	return result, nil
+}
+
+// mustGetTrigger gets the trigger by the id or the name.
+// Returns an error if the trigger doesn't exist.
+func (c *Config) mustGetTrigger(
+	ctx context.Context,
+	triggerService *cloudbuild.ProjectsTriggersService,
+	trigger string,
+) (*cloudbuild.BuildTrigger, error) {
+	result, err := c.getTrigger(ctx, triggerService, trigger)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, NewConfigError(fmt.Sprintf("Trigger %v is not found", trigger), nil)
+	}
+	return result, nil
+}
+
+// triggerToYAML serializes the definition of the trigger to YAML.
+// Fields set by Cloud Build are not included.
+func triggerToYAML(trigger *cloudbuild.BuildTrigger) ([]byte, error) {
+	jsonBody, err := json.Marshal(trigger)
+	if err != nil {
+		return nil, xerrors.Errorf("Failed to serialize trigger %v: %w", trigger.Name, err)
+	}
+	m := make(map[string]interface{})
+	if err := json.Unmarshal(jsonBody, &m); err != nil {
+		return nil, xerrors.Errorf("Failed to deserialize trigger %v: %w", trigger.Name, err)
+	}
+	for _, field := range triggerOutputOnlyFields {
+		deleteField(m, strings.Split(field, "."))
+	}
+	body, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, xerrors.Errorf("Failed to serialize trigger %v: %w", trigger.Name, err)
+	}
+	return body, nil
+}
+
+// deleteField deletes the field at the path from the deserialized JSON object.
+func deleteField(m map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(m, path[0])
+		return
+	}
+	if child, ok := m[path[0]].(map[string]interface{}); ok {
+		deleteField(child, path[1:])
+	}
+}
+
+// triggerFromYAML deserializes the definition of the trigger from YAML.
+func triggerFromYAML(body []byte) (*cloudbuild.BuildTrigger, error) {
+	m := make(map[string]interface{})
+	if err := yaml.Unmarshal(body, &m); err != nil {
+		return nil, xerrors.Errorf("Failed to parse YAML: %w", err)
+	}
+	jsonBody, err := json.Marshal(&m)
+	if err != nil {
+		return nil, xerrors.Errorf("Failed to serialize to JSON: %w", err)
+	}
+	trigger := &cloudbuild.BuildTrigger{}
+	if err := json.Unmarshal(jsonBody, trigger); err != nil {
+		return nil, xerrors.Errorf("Failed to deserialize trigger: %w", err)
+	}
+	return trigger, nil
+}
+
+// describeTriggerSource returns the short description of the repository the trigger watches.
+func describeTriggerSource(trigger *cloudbuild.BuildTrigger) string {
+	if trigger.Github != nil {
+		return fmt.Sprintf("github.com/%v/%v", trigger.Github.Owner, trigger.Github.Name)
+	}
+	if trigger.TriggerTemplate != nil {
+		return trigger.TriggerTemplate.RepoName
+	}
+	if trigger.PubsubConfig != nil {
+		return trigger.PubsubConfig.Topic
+	}
+	if trigger.WebhookConfig != nil {
+		return "webhook"
+	}
+	return "-"
+}
+
+// describeTriggerEvent returns the short description of events starting the trigger.
+func describeTriggerEvent(trigger *cloudbuild.BuildTrigger) string {
+	if trigger.Github != nil {
+		if trigger.Github.Push != nil {
+			if trigger.Github.Push.Tag != "" {
+				return fmt.Sprintf("push tag %v", trigger.Github.Push.Tag)
+			}
+			return fmt.Sprintf("push branch %v", trigger.Github.Push.Branch)
+		}
+		if trigger.Github.PullRequest != nil {
+			return fmt.Sprintf("pull request to %v", trigger.Github.PullRequest.Branch)
+		}
+	}
+	if trigger.TriggerTemplate != nil {
+		if trigger.TriggerTemplate.TagName != "" {
+			return fmt.Sprintf("push tag %v", trigger.TriggerTemplate.TagName)
+		}
+		if trigger.TriggerTemplate.BranchName != "" {
+			return fmt.Sprintf("push branch %v", trigger.TriggerTemplate.BranchName)
+		}
+	}
+	if trigger.PubsubConfig != nil {
+		return "pubsub"
+	}
+	if trigger.WebhookConfig != nil {
+		return "webhook"
+	}
+	return "-"
+}
+
+// CloudBuildTriggerList holds running state of listing triggers
+type CloudBuildTriggerList struct {
+	Config
+
+	// Output is the output format: table or json.
+	Output string
+}
+
+// Execute lists triggers and prints them
+func (l *CloudBuildTriggerList) Execute() error {
+	if l.Output != OutputFormatTable && l.Output != OutputFormatJSON {
+		return NewConfigError(fmt.Sprintf("Unsupported output format '%v'", l.Output), nil)
+	}
+	if err := l.Config.resolveProject(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	triggerService, err := l.Config.newTriggerService(ctx)
+	if err != nil {
+		return err
+	}
+	triggers, err := l.Config.listTriggers(ctx, triggerService)
+	if err != nil {
+		return err
+	}
+	if l.Output == OutputFormatJSON {
+		return printJSON(os.Stdout, triggers)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSOURCE\tEVENT\tFILENAME\tDISABLED")
+	for _, trigger := range triggers {
+		filename := trigger.Filename
+		if filename == "" {
+			filename = "-"
+		}
+		fmt.Fprintf(
+			w,
+			"%v\t%v\t%v\t%v\t%v\t%v\n",
+			trigger.Id,
+			trigger.Name,
+			describeTriggerSource(trigger),
+			describeTriggerEvent(trigger),
+			filename,
+			trigger.Disabled,
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return xerrors.Errorf("Failed to print triggers: %w", err)
+	}
+	return nil
+}
+
+// CloudBuildTriggerDescribe holds running state of describing a trigger
+type CloudBuildTriggerDescribe struct {
+	Config
+
+	// Trigger is the id or the name of the trigger.
+	Trigger string
+
+	// Output is the output format: json or yaml.
+	Output string
+}
+
+// Execute gets the trigger and prints it
+func (d *CloudBuildTriggerDescribe) Execute() error {
+	if d.Output != OutputFormatJSON && d.Output != OutputFormatYAML {
+		return NewConfigError(fmt.Sprintf("Unsupported output format '%v'", d.Output), nil)
+	}
+	if err := d.Config.resolveProject(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	triggerService, err := d.Config.newTriggerService(ctx)
+	if err != nil {
+		return err
+	}
+	trigger, err := d.Config.mustGetTrigger(ctx, triggerService, d.Trigger)
+	if err != nil {
+		return err
+	}
+	d.Config.maskSensitiveSubstitutions(trigger.Substitutions)
+	trigger.Substitutions = maskedSubstitutions(trigger.Substitutions)
+	if d.Output == OutputFormatJSON {
+		return printJSON(os.Stdout, trigger)
+	}
+	return printYAML(os.Stdout, trigger)
+}
+
+// CloudBuildTriggerRun holds running state of running a trigger
+type CloudBuildTriggerRun struct {
+	CloudBuildSubmit
+
+	// Trigger is the id or the name of the trigger.
+	Trigger string
+
+	// Branch is the branch to build.
+	Branch string
+
+	// Tag is the tag to build.
+	Tag string
+
+	// CommitSha is the commit to build.
+	CommitSha string
+}
+
+// Execute runs the trigger and watches the started build
+func (r *CloudBuildTriggerRun) Execute() error {
+	revisions := 0
+	for _, revision := range []string{r.Branch, r.Tag, r.CommitSha} {
+		if revision != "" {
+			revisions++
+		}
+	}
+	if revisions != 1 {
+		return NewConfigError("Specify exactly one of a branch, a tag or a commit sha", nil)
+	}
+	if err := r.Config.resolveProject(); err != nil {
+		return err
+	}
+	var err error
+	if r.ci, err = newCIIntegration(&r.Config); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	triggerService, err := r.Config.newTriggerService(ctx)
+	if err != nil {
+		return err
+	}
+	trigger, err := r.Config.mustGetTrigger(ctx, triggerService, r.Trigger)
+	if err != nil {
+		return err
+	}
+
+	if trigger.TriggerTemplate == nil {
+		// The run API accepts only sources in Cloud Source Repositories.
+		kind := "without Cloud Source Repositories"
+		if trigger.Github != nil {
+			kind = fmt.Sprintf("for GitHub repository %v/%v", trigger.Github.Owner, trigger.Github.Name)
+		}
+		return NewConfigError(
+			fmt.Sprintf("Cannot run trigger %v %v: only triggers for Cloud Source Repositories are supported", trigger.Name, kind),
+			nil,
+		)
+	}
+	source := &cloudbuild.RepoSource{
+		ProjectId:  r.Config.Project,
+		RepoName:   trigger.TriggerTemplate.RepoName,
+		BranchName: r.Branch,
+		TagName:    r.Tag,
+		CommitSha:  r.CommitSha,
+	}
+	if len(r.Config.Substitutions) > 0 {
+		build := &cloudbuild.Build{}
+		if err := applySubstitutions(build, r.Config.Substitutions); err != nil {
+			return NewConfigError("Invalid substitutions", err)
+		}
+		source.Substitutions = build.Substitutions
+	}
+
+	log.WithField("trigger", trigger.Name).
+		WithField("triggerID", trigger.Id).
+		Info("Running trigger")
+	call := triggerService.Run(r.Config.Project, trigger.Id, source)
+	var operation *cloudbuild.Operation
+	if err := retryWithBackoff(r.Config.MaxStartBuildTryCount, "run trigger", func() error {
+		runCtx, cancel := r.Config.cloudBuildContext(ctx)
+		defer cancel()
+		var err error
+		operation, err = call.Context(runCtx).Do()
+		return err
+	}); err != nil {
+		return NewServiceError(fmt.Sprintf("Failed to run trigger %v", r.Trigger), err)
+	}
+	if r.buildID, err = buildIDFromOperation(operation); err != nil {
+		return NewServiceError(fmt.Sprintf("Failed to run trigger %v", r.Trigger), err)
+	}
+	log.WithField("buildID", r.buildID).Info("Build queued")
+
+	return r.watchAndReport()
+}
+
+// CloudBuildTriggerExport holds running state of exporting triggers
+type CloudBuildTriggerExport struct {
+	Config
+
+	// Triggers is the ids or the names of triggers to export. Empty for all triggers.
+	Triggers []string
+
+	// Dir is the directory to write files.
+	Dir string
+}
+
+// unsafeFileNameChars is characters replaced to create file names from trigger names.
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Execute exports triggers to YAML files
+func (e *CloudBuildTriggerExport) Execute() error {
+	if err := e.Config.resolveProject(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	triggerService, err := e.Config.newTriggerService(ctx)
+	if err != nil {
+		return err
+	}
+
+	triggers := []*cloudbuild.BuildTrigger{}
+	if len(e.Triggers) == 0 {
+		if triggers, err = e.Config.listTriggers(ctx, triggerService); err != nil {
+			return err
+		}
+	} else {
+		for _, name := range e.Triggers {
+			trigger, err := e.Config.mustGetTrigger(ctx, triggerService, name)
+			if err != nil {
+				return err
+			}
+			triggers = append(triggers, trigger)
+		}
+	}
+
+	if err := os.MkdirAll(e.Dir, 0755); err != nil {
+		return NewConfigError(fmt.Sprintf("Failed to create %v", e.Dir), err)
+	}
+	for _, trigger := range triggers {
+		body, err := triggerToYAML(trigger)
+		if err != nil {
+			return err
+		}
+		file := filepath.Join(e.Dir, fmt.Sprintf("%v.yaml", unsafeFileNameChars.ReplaceAllString(trigger.Name, "_")))
+		if err := ioutil.WriteFile(file, body, 0644); err != nil {
+			return NewConfigError(fmt.Sprintf("Failed to write %v", file), err)
+		}
+		log.WithField("trigger", trigger.Name).WithField("file", file).Info("Exported trigger")
+	}
+	return nil
+}
+
+// CloudBuildTriggerApply holds running state of applying triggers
+type CloudBuildTriggerApply struct {
+	Config
+
+	// Files is YAML files defining triggers.
+	Files []string
+
+	// DryRun only prints differences without applying them.
+	DryRun bool
+}
+
+// Execute creates or updates triggers as defined in YAML files
+func (a *CloudBuildTriggerApply) Execute() error {
+	if len(a.Files) == 0 {
+		return NewConfigError("Specify files to apply", nil)
+	}
+	if err := a.Config.resolveProject(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	triggerService, err := a.Config.newTriggerService(ctx)
+	if err != nil {
+		return err
+	}
+	for _, file := range a.Files {
+		if err := a.apply(ctx, triggerService, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *CloudBuildTriggerApply) apply(
+	ctx context.Context,
+	triggerService *cloudbuild.ProjectsTriggersService,
+	file string,
+) error {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return NewConfigError(fmt.Sprintf("Failed to read %v", file), err)
+	}
+	trigger, err := triggerFromYAML(body)
+	if err != nil {
+		return NewConfigError(fmt.Sprintf("Failed to read %v", file), err)
+	}
+	if trigger.Name == "" {
+		return NewConfigError(fmt.Sprintf("No name is defined in %v", file), nil)
+	}
+	// Normalize the definition to compare with the current one.
+	// Fields set by Cloud Build are excluded from both of them
+	// not to show differences for definitions exported as they are.
+	desired, err := triggerToYAML(trigger)
+	if err != nil {
+		return err
+	}
+
+	current, err := a.Config.getTrigger(ctx, triggerService, trigger.Name)
+	if err != nil {
+		return err
+	}
+	currentYAML := []byte{}
+	if current != nil {
+		if currentYAML, err = triggerToYAML(current); err != nil {
+			return err
+		}
+	}
+
+	diff := unifiedDiff(
+		fmt.Sprintf("%v (current)", trigger.Name),
+		fmt.Sprintf("%v (%v)", trigger.Name, file),
+		string(currentYAML),
+		string(desired),
+	)
+	if diff == "" {
+		log.WithField("trigger", trigger.Name).WithField("file", file).Info("Trigger is up to date")
+		return nil
+	}
+	fmt.Fprint(os.Stdout, diff)
+	if a.DryRun {
+		return nil
+	}
+
+	var apply func(ctx context.Context) error
+	if current == nil {
+		call := triggerService.Create(a.Config.Project, trigger)
+		attempted := false
+		apply = func(ctx context.Context) error {
+			if attempted {
+				// The failed attempt may have created the trigger like for timeouts.
+				// Don't create it twice.
+				created, err := a.Config.getTrigger(ctx, triggerService, trigger.Name)
+				if err != nil {
+					return err
+				}
+				if created != nil {
+					return nil
+				}
+			}
+			attempted = true
+			_, err := call.Context(ctx).Do()
+			return err
+		}
+	} else {
+		trigger.Id = current.Id
+		call := triggerService.Patch(a.Config.Project, current.Id, trigger)
+		apply = func(ctx context.Context) error {
+			_, err := call.Context(ctx).Do()
+			return err
+		}
+	}
+	if err := retryWithBackoff(a.Config.MaxStartBuildTryCount, "apply trigger", func() error {
+		applyCtx, cancel := a.Config.cloudBuildContext(ctx)
+		defer cancel()
+		return apply(applyCtx)
+	}); err != nil {
+		return NewServiceError(fmt.Sprintf("Failed to apply trigger %v", trigger.Name), err)
+	}
+	if current == nil {
+		log.WithField("trigger", trigger.Name).WithField("file", file).Info("Created trigger")
+	} else {
+		log.WithField("trigger", trigger.Name).WithField("file", file).Info("Updated trigger")
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	cloudbuild "google.golang.org/api/cloudbuild/v1"
+)
+
+func TestTriggerExportedUnchangedHasNoDiff(t *testing.T) {
+	current := &cloudbuild.BuildTrigger{
+		Id:           "0123-4567",
+		Name:         "deploy",
+		CreateTime:   "2021-01-01T00:00:00Z",
+		ResourceName: "projects/my-project/locations/global/triggers/0123-4567",
+		Filename:     "cloudbuild.yaml",
+		Substitutions: map[string]string{
+			"_ENV": "production",
+		},
+		PubsubConfig: &cloudbuild.PubsubConfig{
+			Topic:        "projects/my-project/topics/deploy",
+			Subscription: "projects/my-project/subscriptions/gcb-deploy",
+			State:        "OK",
+		},
+	}
+	exported, err := triggerToYAML(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trigger, err := triggerFromYAML(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desired, err := triggerToYAML(trigger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentYAML, err := triggerToYAML(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := unifiedDiff("current", "desired", string(currentYAML), string(desired)); diff != "" {
+		t.Errorf("expected no differences, got:\n%v", diff)
+	}
+	for _, field := range []string{"id:", "createTime:", "resourceName:", "subscription:", "state:"} {
+		if containsLine(string(exported), field) {
+			t.Errorf("%v is exported:\n%s", field, exported)
+		}
+	}
+}
+
+func containsLine(text, prefix string) bool {
+	for _, line := range splitLines(text) {
+		if strings.HasPrefix(strings.TrimSpace(line), prefix) {
+			return true
+		}
+	}
+	return false
+}
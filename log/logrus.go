@@ -13,6 +13,7 @@ var (
 
 func init() {
 	Logger = logrus.New()
+	SetFormatter(Logger.Formatter)
 }
 
 // Debug outputs debug logs.
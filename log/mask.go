@@ -0,0 +1,74 @@
+package log
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MaskText is the text to replace sensitive values with.
+const MaskText = "***"
+
+var (
+	secretsMutex sync.RWMutex
+	secrets      []string
+)
+
+// AddSecret registers the value to be masked in log outputs.
+// Empty values are ignored.
+func AddSecret(value string) {
+	if value == "" {
+		return
+	}
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	for _, secret := range secrets {
+		if secret == value {
+			return
+		}
+	}
+	secrets = append(secrets, value)
+	// Replace longer values first not to leave parts of them
+	// when a secret contains another.
+	sort.SliceStable(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+}
+
+// HasSecrets returns whether any secrets are registered.
+func HasSecrets() bool {
+	secretsMutex.RLock()
+	defer secretsMutex.RUnlock()
+	return len(secrets) > 0
+}
+
+// Mask replaces registered secrets in the text with MaskText.
+func Mask(text string) string {
+	secretsMutex.RLock()
+	defer secretsMutex.RUnlock()
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, secret, MaskText)
+	}
+	return text
+}
+
+// maskFormatter redacts fields of sensitive keys and masks secrets
+// in outputs of the wrapped formatter.
+type maskFormatter struct {
+	logrus.Formatter
+}
+
+func (f *maskFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	b, err := f.Formatter.Format(redactEntry(entry))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(Mask(string(b))), nil
+}
+
+// SetFormatter sets the formatter of Logger redacting sensitive values in its outputs.
+func SetFormatter(formatter logrus.Formatter) {
+	Logger.SetFormatter(&maskFormatter{Formatter: formatter})
+}
@@ -0,0 +1,90 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// FormatText is the human friendly format of logrus.
+	FormatText = "text"
+	// FormatJSON outputs each log as a JSON object.
+	FormatJSON = "json"
+	// FormatLogfmt outputs each log as key=value pairs.
+	FormatLogfmt = "logfmt"
+
+	// OutputStderr writes logs to the standard error.
+	OutputStderr = "stderr"
+)
+
+// detailedErrors renders errors with their stack frames if true.
+var detailedErrors bool
+
+// output is the current destination of logs. Either OutputStderr or a file path.
+var output = OutputStderr
+
+// outputFile is the file opened for logs.
+var outputFile io.Closer
+
+// SetFormatByName configures the format of logs: text, json or logfmt.
+// Errors are rendered with their stack frames in json and logfmt
+// as those logs are likely to be collected and investigated later.
+func SetFormatByName(format string) error {
+	switch format {
+	case FormatText:
+		SetFormatter(&logrus.TextFormatter{})
+		detailedErrors = false
+	case FormatJSON:
+		SetFormatter(&logrus.JSONFormatter{})
+		detailedErrors = true
+	case FormatLogfmt:
+		SetFormatter(&logrus.TextFormatter{
+			DisableColors: true,
+			FullTimestamp: true,
+		})
+		detailedErrors = true
+	default:
+		return xerrors.Errorf("Invalid log format %v: must be text, json or logfmt", format)
+	}
+	return nil
+}
+
+// SetOutputByName configures the destination of logs: stderr or a path of a file.
+// Logs are appended to the file.
+func SetOutputByName(name string) error {
+	if name == "" {
+		name = OutputStderr
+	}
+	if name == output {
+		return nil
+	}
+	var w io.Writer = os.Stderr
+	var closer io.Closer
+	if name != OutputStderr {
+		fd, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return xerrors.Errorf("Failed to open the log file %v: %w", name, err)
+		}
+		w = fd
+		closer = fd
+	}
+	Logger.SetOutput(w)
+	if outputFile != nil {
+		outputFile.Close()
+	}
+	output = name
+	outputFile = closer
+	return nil
+}
+
+// formatError renders the error for logs.
+func formatError(err error) string {
+	if detailedErrors {
+		return fmt.Sprintf("%+v", err)
+	}
+	return fmt.Sprintf("%v", err)
+}
@@ -0,0 +1,189 @@
+package log
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// DefaultSensitiveKeyPatterns is the default patterns of keys whose values are redacted.
+var DefaultSensitiveKeyPatterns = []string{
+	"*TOKEN*",
+	"*PASSWORD*",
+	"_SECRET_*",
+}
+
+// maxRedactDepth limits the depth to walk nested values not to loop for cyclic references.
+const maxRedactDepth = 10
+
+var (
+	sensitiveMutex        sync.RWMutex
+	sensitiveKeyPatterns  = append([]string{}, DefaultSensitiveKeyPatterns...)
+	explicitSensitiveKeys = make(map[string]bool)
+)
+
+// SetSensitiveKeyPatterns replaces patterns of keys whose values are redacted.
+// Patterns are globs like *TOKEN* matched case-insensitively.
+func SetSensitiveKeyPatterns(patterns []string) error {
+	normalized := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = strings.ToUpper(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return xerrors.Errorf("Invalid pattern %v: %w", pattern, err)
+		}
+		normalized = append(normalized, pattern)
+	}
+	sensitiveMutex.Lock()
+	defer sensitiveMutex.Unlock()
+	sensitiveKeyPatterns = normalized
+	return nil
+}
+
+// AddSensitiveKey registers the key whose values are redacted
+// regardless of patterns.
+func AddSensitiveKey(key string) {
+	sensitiveMutex.Lock()
+	defer sensitiveMutex.Unlock()
+	explicitSensitiveKeys[strings.ToUpper(key)] = true
+}
+
+// IsSensitiveKey returns whether values of the key are redacted.
+func IsSensitiveKey(key string) bool {
+	key = strings.ToUpper(key)
+	sensitiveMutex.RLock()
+	defer sensitiveMutex.RUnlock()
+	if explicitSensitiveKeys[key] {
+		return true
+	}
+	for _, pattern := range sensitiveKeyPatterns {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// redactEntry returns a copy of the entry whose fields are redacted,
+// and errors are rendered in the configured detail.
+func redactEntry(entry *logrus.Entry) *logrus.Entry {
+	if len(entry.Data) == 0 {
+		return entry
+	}
+	redacted := *entry
+	redacted.Data = make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		if key == logrus.ErrorKey {
+			// errors are masked only with values of secrets
+			// not to lose their details.
+			if err, ok := value.(error); ok {
+				redacted.Data[key] = formatError(err)
+			} else {
+				redacted.Data[key] = value
+			}
+			continue
+		}
+		if IsSensitiveKey(key) {
+			redacted.Data[key] = MaskText
+			continue
+		}
+		redacted.Data[key] = Redact(value)
+	}
+	return &redacted
+}
+
+// Redact returns a copy of the value where values of sensitive keys are replaced with MaskText.
+// Structs are converted to maps of exported fields,
+// and KEY=VALUE expressions in strings are redacted for sensitive KEYs.
+func Redact(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	return redactValue(reflect.ValueOf(value), 0)
+}
+
+var (
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+	stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+func redactValue(v reflect.Value, depth int) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if depth > maxRedactDepth {
+		return fmt.Sprintf("%v", v)
+	}
+	if v.Type().Implements(errorType) || v.Type().Implements(stringerType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return nil
+		}
+		return v.Interface()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return redactValue(v.Elem(), depth+1)
+	case reflect.String:
+		return redactExpression(v.String())
+	case reflect.Struct:
+		t := v.Type()
+		m := make(map[string]interface{}, t.NumField())
+		for idx := 0; idx < t.NumField(); idx++ {
+			field := t.Field(idx)
+			if field.PkgPath != "" {
+				// unexported
+				continue
+			}
+			m[field.Name] = redactField(field.Name, v.Field(idx), depth)
+		}
+		return m
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return v.Interface()
+		}
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			m[key] = redactField(key, iter.Value(), depth)
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			// []byte
+			return v.Interface()
+		}
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		s := make([]interface{}, v.Len())
+		for idx := 0; idx < v.Len(); idx++ {
+			s[idx] = redactValue(v.Index(idx), depth+1)
+		}
+		return s
+	}
+	return v.Interface()
+}
+
+func redactField(key string, v reflect.Value, depth int) interface{} {
+	if IsSensitiveKey(key) && v.IsValid() && !v.IsZero() {
+		return MaskText
+	}
+	return redactValue(v, depth+1)
+}
+
+// redactExpression redacts the value of KEY=VALUE expressions for sensitive KEYs.
+func redactExpression(s string) string {
+	keyValue := strings.SplitN(s, "=", 2)
+	if len(keyValue) == 2 && keyValue[0] != "" && IsSensitiveKey(keyValue[0]) {
+		return fmt.Sprintf("%v=%v", keyValue[0], MaskText)
+	}
+	return s
+}